@@ -8,24 +8,134 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httprate"
+	"github.com/go-webauthn/webauthn/webauthn"
 
+	"github.com/ssanjose/PingU/internal/auth"
+	"github.com/ssanjose/PingU/internal/mailer"
 	"github.com/ssanjose/PingU/internal/store"
 )
 
 type application struct {
-	config config
-	store  store.Storage
+	config                    config
+	store                     store.Storage
+	authenticator             auth.Authenticator
+	keyRing                   *auth.KeyRing
+	mailer                    mailer.Mailer
+	apiKeyLimiter             *httprate.RateLimiter
+	magicLinkLimiter          *httprate.RateLimiter
+	resendActivationLimiter   *httprate.RateLimiter
+	partnerInviteLimiter      *httprate.RateLimiter
+	partnerEmailInviteLimiter *httprate.RateLimiter
+	webAuthnLoginLimiter      *httprate.RateLimiter
+	oidcProviders             map[string]*auth.OIDCProvider
+	webAuthn                  *webauthn.WebAuthn
+	validation                *validationRules
 }
 
 type config struct {
-	addr string
-	db   dbConfig
-	env  string
-	mail mailConfig
+	addr        string
+	db          dbConfig
+	env         string
+	mail        mailConfig
+	auth        authConfig
+	janitor     janitorConfig
+	partner     partnerConfig
+	validation  validationConfig
+	apiURL      string
+	frontendURL string
 }
 
-type mailConfig struct {
+// partnerConfig limits how often a user may try to redeem an invite code,
+// codes are short enough to be guessed otherwise, and how many invites a user
+// may email.
+type partnerConfig struct {
+	requestExp         time.Duration
+	inviteExp          time.Duration
+	redeemsPerHour     int
+	emailInviteExp     time.Duration
+	emailInvitesPerDay int
+}
+
+type janitorConfig struct {
+	interval        time.Duration
+	unverifiedGrace time.Duration
+	batchSize       int
+}
+
+type authConfig struct {
+	token            tokenConfig
+	signingKeys      signingKeyConfig
+	resetExp         time.Duration
+	emailExp         time.Duration
+	refreshExp       time.Duration
+	apiKey           apiKeyConfig
+	oidc             []oidcConfig
+	lockout          store.LockoutPolicy
+	webAuthn         webAuthnConfig
+	magicLink        magicLinkConfig
+	resendActivation resendActivationConfig
+	argon2           store.Argon2Params
+}
+
+// resendActivationConfig limits activation email resends per account.
+type resendActivationConfig struct {
+	requestsPerHour int
+}
+
+// magicLinkConfig turns magic links on for the deployment, users can still
+// opt out on their own. Requests are limited per email address.
+type magicLinkConfig struct {
+	enabled         bool
+	exp             time.Duration
+	requestsPerHour int
+}
+
+// webAuthnConfig limits how many passkey logins an IP may begin, as every
+// one stores a ceremony before anyone is authenticated.
+type webAuthnConfig struct {
+	rpID            string
+	rpDisplayName   string
+	rpOrigins       []string
+	loginsPerMinute int
+}
+
+type oidcConfig struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+}
+
+type apiKeyConfig struct {
+	requestsPerMinute int
+}
+
+type tokenConfig struct {
 	exp time.Duration
+	iss string
+}
+
+// signingKeyConfig either points at a directory of PEM key files, or
+// configures the keys generated into the database.
+type signingKeyConfig struct {
+	dir        string
+	signingKID string
+	algorithm  string
+	rotation   store.KeyRotationPolicy
+}
+
+type mailConfig struct {
+	exp       time.Duration
+	fromEmail string
+	outboxDir string
+	smtp      smtpConfig
+}
+
+type smtpConfig struct {
+	host     string
+	port     int
+	username string
+	password string
 }
 
 type dbConfig struct {
@@ -52,27 +162,128 @@ func (app *application) mount() http.Handler {
 	// processing should be stopped.
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
+
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/health", app.healthCheckHandler)
 
 		r.Route("/users", func(r chi.Router) {
+			r.Put("/activate/{token}", app.activateUserHandler)
+			r.Put("/email/confirm/{token}", app.confirmEmailChangeHandler)
+
 			r.Route("/{userID}", func(r chi.Router) {
-				r.Use(app.userContextMiddleware)
+				r.Use(app.AuthTokenMiddleware)
+
+				r.With(app.denyAPIKeys).Delete("/", app.checkPermission("admin", app.deleteUserHandler))
+				r.With(app.denyAPIKeys).Put("/unpartner", app.checkPermission("moderator", app.unsetUserPartnerHandler))
+				r.With(app.denyAPIKeys).Delete("/lockout", app.checkPermission("admin", app.unlockUserHandler))
+
+				r.Group(func(r chi.Router) {
+					// ownership is checked before the user is loaded, so a
+					// missing user looks the same as someone else's
+					r.Use(app.checkUserOwnership)
+					r.Use(app.userContextMiddleware)
+
+					r.With(app.checkAPIKeyScope(store.ScopeRead)).Get("/", app.getUserHandler)
+					r.With(app.checkAPIKeyScope(store.ScopeRead)).Get("/partnerships", app.getPartnershipsHandler)
+					r.With(app.checkAPIKeyScope(store.ScopePing)).Put("/ping", app.pingUserPartnerHandler)
+					r.With(app.checkAPIKeyScope(store.ScopePong)).Put("/pong", app.pongUserPartnerHandler)
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.denyAPIKeys)
+
+						r.Patch("/", app.updateUserHandler)
+						r.Put("/partner/{partnerID}", app.setUserPartnerHandler)
+
+						r.Route("/partner-requests", func(r chi.Router) {
+							r.Post("/", app.createPartnerRequestHandler)
+							r.Get("/", app.getPartnerRequestsHandler)
+							r.Put("/{requestID}/accept", app.acceptPartnerRequestHandler)
+							r.Put("/{requestID}/decline", app.declinePartnerRequestHandler)
+							r.Put("/{requestID}/cancel", app.cancelPartnerRequestHandler)
+						})
+
+						r.Post("/partner-invites", app.createPartnerInviteHandler)
+
+						r.Route("/partner-email-invites", func(r chi.Router) {
+							r.Post("/", app.createPartnerEmailInviteHandler)
+							r.Get("/", app.getPartnerEmailInvitesHandler)
+							r.Delete("/{inviteID}", app.deletePartnerEmailInviteHandler)
+						})
+
+						r.Route("/blocks", func(r chi.Router) {
+							r.Post("/", app.blockUserHandler)
+							r.Get("/", app.getUserBlocksHandler)
+							r.Delete("/{blockedID}", app.unblockUserHandler)
+						})
+
+						r.Route("/sessions", func(r chi.Router) {
+							r.Get("/", app.getUserSessionsHandler)
+							r.Delete("/", app.deleteUserSessionsHandler)
+							r.Delete("/{sessionID}", app.deleteUserSessionHandler)
+						})
+
+						r.Route("/2fa", func(r chi.Router) {
+							r.Post("/totp", app.enrollTOTPHandler)
+							r.Post("/totp/confirm", app.confirmTOTPHandler)
+							r.Delete("/totp", app.disableTOTPHandler)
+							r.Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+						})
 
-				r.Get("/", app.getUserHandler)
-				r.Patch("/", app.updateUserHandler)
-				r.Delete("/", app.deleteUserHandler)
+						r.Get("/security/events", app.getSecurityEventsHandler)
 
-				r.Put("/partner/{partnerID}", app.setUserPartnerHandler)
-				r.Put("/unpartner", app.unsetUserPartnerHandler)
-				r.Put("/ping", app.pingUserPartnerHandler)
-				r.Put("/pong", app.pongUserPartnerHandler)
+						r.Route("/webauthn/credentials", func(r chi.Router) {
+							r.Get("/", app.getWebAuthnCredentialsHandler)
+							r.Patch("/{credentialID}", app.renameWebAuthnCredentialHandler)
+							r.Delete("/{credentialID}", app.deleteWebAuthnCredentialHandler)
+						})
+
+						r.Route("/api-keys", func(r chi.Router) {
+							r.Post("/", app.createAPIKeyHandler)
+							r.Get("/", app.getAPIKeysHandler)
+							r.Delete("/{apiKeyID}", app.deleteAPIKeyHandler)
+						})
+					})
+				})
 			})
 		})
 
+		r.Route("/partner-invites", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.denyAPIKeys)
+
+			r.Post("/{code}/redeem", app.redeemPartnerInviteHandler)
+		})
+
 		r.Route("/authentication", func(r chi.Router) {
 			r.Post("/user", app.registerUserHandler)
+			r.Post("/resend-activation", app.resendActivationHandler)
 			r.Post("/login", app.loginUserHandler)
+			r.Post("/2fa", app.verifyTwoFactorHandler)
+			r.Post("/refresh", app.refreshTokenHandler)
+			r.Post("/logout", app.logoutUserHandler)
+			r.Post("/password/forgot", app.forgotPasswordHandler)
+			r.Post("/password/reset", app.resetPasswordHandler)
+			r.Get("/oidc/{provider}/start", app.oidcStartHandler)
+			r.Get("/oidc/{provider}/callback", app.oidcCallbackHandler)
+
+			if app.config.auth.magicLink.enabled {
+				r.Post("/magic-link", app.requestMagicLinkHandler)
+				r.Get("/magic-link/{token}", app.magicLinkLoginHandler)
+			}
+
+			r.Route("/webauthn", func(r chi.Router) {
+				r.Post("/login/begin", app.beginWebAuthnLoginHandler)
+				r.Post("/login/finish", app.finishWebAuthnLoginHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.denyAPIKeys)
+
+					r.Post("/register/begin", app.beginWebAuthnRegistrationHandler)
+					r.Post("/register/finish", app.finishWebAuthnRegistrationHandler)
+				})
+			})
 		})
 	})
 
@@ -92,3 +303,17 @@ func (app *application) run(mux http.Handler) error {
 
 	return srv.ListenAndServe()
 }
+
+// background runs fn in its own goroutine, recovering from any panic so a
+// failed background job never takes the server down.
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("background job panicked: %v", err)
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const (
+	apiKeyHeader         = "X-API-Key"
+	apiKeyPrefix         = "pingu_"
+	apiKeyCtx    userKey = "apiKey"
+)
+
+// authenticateAPIKey is the API key branch of AuthTokenMiddleware. Every key
+// gets its own rate limit on top of the global one.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plainKey string) {
+	ctx := r.Context()
+
+	key, err := app.store.APIKeys.GetByToken(ctx, hashToken(plainKey))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.recordUnknownLoginEvent(w, r, "", store.LoginMethodAPIKey)
+			app.unauthorizedErrorResponse(w, r, errors.New("invalid api key"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if app.apiKeyLimiter.RespondOnLimit(w, r, strconv.FormatInt(key.ID, 10)) {
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, key.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.recordLoginEvent(w, r, user, store.LoginMethodAPIKey, store.LoginOutcomeSuccess)
+
+	ctx = context.WithValue(ctx, authUserCtx, user)
+	ctx = context.WithValue(ctx, apiKeyCtx, key)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// checkAPIKeyScope rejects requests authenticated by an API key that wasn't
+// granted scope. Requests with a bearer token go through untouched.
+func (app *application) checkAPIKeyScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := getAPIKeyFromCtx(r)
+			if key != nil && !key.HasScope(scope) {
+				app.forbiddenResponse(w, r, fmt.Errorf("api key %d lacks the %s scope", key.ID, scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// denyAPIKeys only lets requests with a bearer token through, for routes that
+// no API key scope covers.
+func (app *application) denyAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := getAPIKeyFromCtx(r); key != nil {
+			app.forbiddenResponse(w, r, fmt.Errorf("api key %d cannot access this route", key.ID))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getAPIKeyFromCtx(r *http.Request) *store.APIKey {
+	key, _ := r.Context().Value(apiKeyCtx).(*store.APIKey)
+	return key
+}
+
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=ping pong read"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty"`
+}
+
+type APIKeyWithToken struct {
+	*store.APIKey
+	Token string `json:"token"`
+}
+
+// createAPIKeyHandler godoc
+//
+// @Summary Create an API key
+// @Description Create a named, scoped API key. The key is only ever returned by this call.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param payload body CreateAPIKeyPayload true "API key"
+// @Success 201 {object} APIKeyWithToken "API key created"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/api-keys [post]
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload CreateAPIKeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.ExpiresAt != nil && payload.ExpiresAt.Before(time.Now()) {
+		app.badRequestResponse(w, r, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	plainKey, err := generateSecureToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	plainKey = apiKeyPrefix + plainKey
+
+	key := &store.APIKey{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Token:  hashToken(plainKey),
+		Scopes: payload.Scopes,
+	}
+
+	if payload.ExpiresAt != nil {
+		key.Expiry = sql.NullTime{Time: *payload.ExpiresAt, Valid: true}
+	}
+
+	if err := app.store.APIKeys.Create(r.Context(), key); err != nil {
+		switch err {
+		case store.ErrDuplicateAPIKeyName:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, &APIKeyWithToken{APIKey: key, Token: plainKey}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	keys, err := app.store.APIKeys.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, keys); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "apiKeyID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.APIKeys.Delete(r.Context(), user.ID, id); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/ssanjose/PingU/internal/mailer"
 	"github.com/ssanjose/PingU/internal/store"
 )
 
 type RegisterUserPayload struct {
-	Username string `json:"username" validate:"required,max=35"`
+	Username string `json:"username" validate:"required,max=35,username,notreserved"`
 	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,min=6,max=72"`
+	Password string `json:"password" validate:"required,min=6,max=256,password,notbreached"`
 }
 
 // registerUserHandler godoc
@@ -34,6 +43,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	payload.Username = normalizeUsername(payload.Username)
+
 	if err := Validate.Struct(payload); err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -45,7 +56,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// hash the user password
-	if err := user.Password.Set(payload.Password); err != nil {
+	if err := user.Password.Set(payload.Password, app.config.auth.argon2); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -54,11 +65,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	plainToken := uuid.New().String()
 
-	hash := sha256.Sum256([]byte(plainToken))
-	hashToken := hex.EncodeToString(hash[:])
-
 	// store the user
-	err := app.store.Users.CreateAndInvite(ctx, user, hashToken, app.config.mail.exp)
+	err := app.store.Users.CreateAndInvite(ctx, user, hashToken(plainToken), app.config.mail.exp)
 	if err != nil {
 		switch err {
 		case store.ErrDuplicateEmail:
@@ -72,6 +80,18 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// send mail
+	err = app.sendActivationEmail(user, plainToken)
+	if err != nil {
+		log.Printf("error sending welcome email: %s", err.Error())
+
+		// rollback user creation if email fails, the invitation cascades
+		if err := app.store.Users.Delete(ctx, user.ID); err != nil {
+			log.Printf("error deleting user: %s", err.Error())
+		}
+
+		app.internalServerError(w, r, err)
+		return
+	}
 
 	if err := app.jsonResponse(w, http.StatusCreated, user); err != nil {
 		app.internalServerError(w, r, err)
@@ -79,6 +99,237 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (app *application) sendActivationEmail(user *store.User, plainToken string) error {
+	vars := struct {
+		Username      string
+		ActivationURL string
+	}{
+		Username:      user.Username,
+		ActivationURL: fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken),
+	}
+
+	return app.mailer.Send(mailer.UserWelcomeTemplate, user.Username, user.Email, vars)
+}
+
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// resendActivationHandler godoc
+//
+// @Summary Resend the activation email
+// @Description Replace the invitation of an unverified user and email a new activation link. Always accepted so that registered emails can't be enumerated.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body ResendActivationPayload true "User email"
+// @Success 202 {string} string "Resend requested"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 429 {object} ErrorResponse "Too many resends for this account"
+// @Router /v1/authentication/resend-activation [post]
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// accounts are keyed by their case insensitive email, whether they exist
+	// or not, so the limit doesn't give them away either
+	if app.resendActivationLimiter.RespondOnLimit(w, r, strings.ToLower(payload.Email)) {
+		return
+	}
+
+	app.background(func() {
+		if err := app.resendActivation(context.Background(), payload.Email); err != nil {
+			log.Printf("error resending activation: %s", err.Error())
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (app *application) resendActivation(ctx context.Context, email string) error {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if user.Verified {
+		return nil
+	}
+
+	plainToken := uuid.New().String()
+
+	if err := app.store.Users.Reinvite(ctx, user.ID, hashToken(plainToken), app.config.mail.exp); err != nil {
+		return err
+	}
+
+	return app.sendActivationEmail(user, plainToken)
+}
+
+type LoginUserPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=6,max=256"`
+}
+
+type TokenResponse struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// loginUserHandler godoc
+//
+// @Summary Log in a user
+// @Description Exchange the user's credentials for a signed access token
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body LoginUserPayload true "User credentials"
+// @Success 200 {object} TokenResponse "Access and refresh tokens, or an MFAChallengeResponse when two-factor authentication is enabled"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid credentials"
+// @Failure 403 {object} ErrorResponse "Account not activated"
+// @Failure 429 {object} ErrorResponse "Too many failed logins"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/login [post]
 func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request) {
-	app.jsonResponse(w, http.StatusOK, nil)
+	var payload LoginUserPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if app.checkLoginLockout(w, r, payload.Email) {
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			// hash the password anyway, so the response time doesn't tell
+			// which emails have an account
+			var nobody store.User
+			_ = nobody.Password.Set(payload.Password, app.config.auth.argon2)
+
+			app.recordLoginFailure(r, payload.Email, nil)
+			app.recordUnknownLoginEvent(w, r, payload.Email, store.LoginMethodPassword)
+			app.unauthorizedErrorResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.recordLoginFailure(r, payload.Email, user)
+		app.recordLoginEvent(w, r, user, store.LoginMethodPassword, store.LoginOutcomeFailure)
+		app.unauthorizedErrorResponse(w, r, errInvalidCredentials)
+		return
+	}
+
+	app.clearLoginFailures(r.Context(), payload.Email)
+
+	// the plain text password is only known here, so upgrade outdated
+	// hashes while we have it
+	if user.Password.NeedsRehash(app.config.auth.argon2) {
+		if err := app.rehashPassword(r.Context(), user, payload.Password); err != nil {
+			log.Printf("error rehashing password of user %d: %s", user.ID, err.Error())
+		}
+	}
+
+	if !user.Verified {
+		app.forbiddenResponse(w, r, fmt.Errorf("user %d has not activated their account", user.ID))
+		return
+	}
+
+	// users with two-factor authentication get a challenge instead of tokens
+	twoFactor, err := app.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if twoFactor {
+		challenge, err := app.createMFAChallenge(r.Context(), user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		app.recordLoginEvent(w, r, user, store.LoginMethodPassword, store.LoginOutcomeChallenged)
+
+		if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.createSession(r, user)
+	if err != nil {
+		app.createSessionErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordLoginEvent(w, r, user, store.LoginMethodPassword, store.LoginOutcomeSuccess)
+
+	if err := app.jsonResponse(w, http.StatusOK, token); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) rehashPassword(ctx context.Context, user *store.User, password string) error {
+	if err := user.Password.Set(password, app.config.auth.argon2); err != nil {
+		return err
+	}
+
+	return app.store.Users.UpdatePassword(ctx, user)
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a plain token, which is
+// the only form tokens are persisted in.
+func hashToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// generateAccessToken signs a short-lived access token for the user.
+func (app *application) generateAccessToken(user *store.User) (*TokenResponse, error) {
+	now := time.Now()
+	exp := now.Add(app.config.auth.token.exp)
+
+	claims := jwt.MapClaims{
+		"sub": strconv.FormatInt(user.ID, 10),
+		"exp": exp.Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.iss,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{Token: token, ExpiresAt: exp}, nil
 }
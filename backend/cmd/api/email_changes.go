@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ssanjose/PingU/internal/mailer"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+// checkEmailAvailable fails with store.ErrDuplicateEmail when another
+// account already uses the email.
+func (app *application) checkEmailAvailable(ctx context.Context, email string) error {
+	_, err := app.store.Users.GetByEmail(ctx, email)
+	switch err {
+	case nil:
+		return store.ErrDuplicateEmail
+	case store.ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+}
+
+// requestEmailChange stages the change of the user's email, replacing any
+// earlier pending change, and mails a confirmation link to the new address
+// and a notice to the current one. The mails go out off the request, a failed
+// one is only logged and the user can ask for the change again.
+func (app *application) requestEmailChange(ctx context.Context, user *store.User, email string) error {
+	plainToken := uuid.New().String()
+
+	if err := app.store.EmailChanges.Create(ctx, user.ID, email, hashToken(plainToken), app.config.auth.emailExp); err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username   string
+		ConfirmURL string
+		Expiry     string
+	}{
+		Username:   user.Username,
+		ConfirmURL: fmt.Sprintf("%s/email/confirm/%s", app.config.frontendURL, plainToken),
+		Expiry:     app.config.auth.emailExp.String(),
+	}
+
+	notice := struct {
+		Username string
+		NewEmail string
+		ResetURL string
+	}{
+		Username: user.Username,
+		NewEmail: email,
+		ResetURL: fmt.Sprintf("%s/password/forgot", app.config.frontendURL),
+	}
+
+	app.background(func() {
+		if err := app.mailer.Send(mailer.EmailChangeTemplate, user.Username, email, vars); err != nil {
+			log.Printf("error sending email change confirmation: %s", err.Error())
+			return
+		}
+
+		if err := app.mailer.Send(mailer.EmailNoticeTemplate, user.Username, user.Email, notice); err != nil {
+			log.Printf("error sending email change notice: %s", err.Error())
+		}
+	})
+
+	return nil
+}
+
+// confirmEmailChangeHandler godoc
+//
+// @Summary Confirm an email change
+// @Description Switch the account to the new email once the new address follows its confirmation link
+// @Tags users
+// @Produce json
+// @Param token path string true "Email change token"
+// @Success 204 {string} string "Email changed"
+// @Failure 404 {object} ErrorResponse "Unknown token"
+// @Failure 409 {object} ErrorResponse "Email taken in the meantime"
+// @Failure 410 {object} ErrorResponse "Expired token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/email/confirm/{token} [put]
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	err := app.store.EmailChanges.Confirm(r.Context(), hashToken(token))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrTokenExpired:
+			app.goneResponse(w, r, err)
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
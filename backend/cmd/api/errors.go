@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/go-playground/validator/v10"
 )
 
 func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
@@ -14,6 +17,12 @@ func (app *application) internalServerError(w http.ResponseWriter, r *http.Reque
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	log.Printf("bad request error: %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
 
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		writeJSONValidationError(w, validationMessages(validationErrs))
+		return
+	}
+
 	writeJSONError(w, http.StatusBadRequest, err.Error())
 }
 
@@ -22,3 +31,35 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request,
 
 	writeJSONError(w, http.StatusNotFound, "Resource not found.")
 }
+
+func (app *application) unauthorizedErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("unauthorized error: %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
+
+	writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+}
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("forbidden error: %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
+
+	writeJSONError(w, http.StatusForbidden, "forbidden")
+}
+
+func (app *application) goneResponse(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("gone error: %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
+
+	writeJSONError(w, http.StatusGone, err.Error())
+}
+
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("conflict error: %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
+
+	writeJSONError(w, http.StatusConflict, err.Error())
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter string) {
+	log.Printf("rate limit exceeded: %s path: %s", r.Method, r.URL.Path)
+
+	w.Header().Set("Retry-After", retryAfter)
+
+	writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter+"s")
+}
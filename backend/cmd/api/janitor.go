@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// validate rejects a config the janitor can't run with: a zero interval makes
+// the ticker panic in the background, a batch size below one never finishes a
+// table, and a grace below zero deletes users who just registered.
+func (cfg janitorConfig) validate() error {
+	if cfg.interval <= 0 {
+		return fmt.Errorf("janitor interval must be positive, got %s", cfg.interval)
+	}
+
+	if cfg.batchSize < 1 {
+		return fmt.Errorf("janitor batch size must be at least 1, got %d", cfg.batchSize)
+	}
+
+	if cfg.unverifiedGrace < 0 {
+		return fmt.Errorf("janitor unverified grace must not be negative, got %s", cfg.unverifiedGrace)
+	}
+
+	return nil
+}
+
+// runJanitor cleans up after registrations that were never finished, every
+// janitor interval until the process exits.
+func (app *application) runJanitor() {
+	ticker := time.NewTicker(app.config.janitor.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.cleanup(context.Background())
+	}
+}
+
+// cleanup deletes expired invitations, then the users that are still
+// unverified past the grace period and have no invitation left, expires stale
+// partner requests and deletes expired partner invite codes, email invites,
+// reauthentication tokens and passkey ceremonies. It works one batch at a time
+// so no table is held up for long.
+func (app *application) cleanup(ctx context.Context) {
+	cfg := app.config.janitor
+
+	invitations, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.Users.DeleteExpiredInvitations(ctx, limit)
+	})
+	if err != nil {
+		log.Printf("error deleting expired invitations: %s", err.Error())
+	}
+
+	createdBefore := time.Now().Add(-cfg.unverifiedGrace)
+	users, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.Users.DeleteUnverified(ctx, createdBefore, limit)
+	})
+	if err != nil {
+		log.Printf("error deleting unverified users: %s", err.Error())
+	}
+
+	requests, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.PartnerRequests.ExpireStale(ctx, limit)
+	})
+	if err != nil {
+		log.Printf("error expiring partner requests: %s", err.Error())
+	}
+
+	invites, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.PartnerInvites.DeleteExpired(ctx, limit)
+	})
+	if err != nil {
+		log.Printf("error deleting expired partner invites: %s", err.Error())
+	}
+
+	emailInvites, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.PartnerEmailInvites.DeleteExpired(ctx, limit)
+	})
+	if err != nil {
+		log.Printf("error deleting expired partner email invites: %s", err.Error())
+	}
+
+	reauthTokens, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.ReauthTokens.DeleteExpired(ctx, limit)
+	})
+	if err != nil {
+		log.Printf("error deleting expired reauthentication tokens: %s", err.Error())
+	}
+
+	ceremonies, err := deleteInBatches(cfg.batchSize, func(limit int) (int64, error) {
+		return app.store.WebAuthn.DeleteExpiredCeremonies(ctx, limit)
+	})
+	if err != nil {
+		log.Printf("error deleting expired passkey ceremonies: %s", err.Error())
+	}
+
+	log.Printf(
+		"janitor removed %d expired invitations, %d unverified users, %d partner invites, %d partner email invites, %d reauthentication tokens and %d passkey ceremonies, expired %d partner requests",
+		invitations, users, invites, emailInvites, reauthTokens, ceremonies, requests,
+	)
+}
+
+// deleteInBatches calls deleteBatch until a batch comes back short, and
+// returns the total number of deleted rows.
+func deleteInBatches(batchSize int, deleteBatch func(limit int) (int64, error)) (int64, error) {
+	var total int64
+
+	for {
+		n, err := deleteBatch(batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -11,6 +11,7 @@ var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+	registerValidations(Validate)
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) error {
@@ -38,6 +39,15 @@ func writeJSONError(w http.ResponseWriter, status int, message string) error {
 	return writeJSON(w, status, &envelope{Error: message})
 }
 
+func writeJSONValidationError(w http.ResponseWriter, fields map[string]string) error {
+	type envelope struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+
+	return writeJSON(w, http.StatusBadRequest, &envelope{Error: "validation failed", Fields: fields})
+}
+
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
 	type envelope struct {
 		Data any `json:"data"`
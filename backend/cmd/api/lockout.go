@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ssanjose/PingU/internal/mailer"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+// lockedOutError is returned by createSession when the account or the IP is
+// locked out, whichever way the user signed in.
+type lockedOutError struct {
+	until time.Time
+}
+
+func (e *lockedOutError) Error() string {
+	return "login is locked out until " + e.until.Format(time.RFC3339)
+}
+
+// checkLoginLockout responds with 429 and returns true when the email or the
+// IP of the login attempt is locked out.
+func (app *application) checkLoginLockout(w http.ResponseWriter, r *http.Request, email string) bool {
+	lockedUntil, err := app.store.LoginThrottles.LockedUntil(r.Context(), email, clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return true
+	}
+
+	if lockedUntil.IsZero() {
+		return false
+	}
+
+	app.lockedOutResponse(w, r, lockedUntil)
+
+	return true
+}
+
+func (app *application) lockedOutResponse(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	app.rateLimitExceededResponse(w, r, strconv.Itoa(max(retryAfter, 1)))
+}
+
+// createSessionErrorResponse answers a failed createSession, with 429 when
+// the login is locked out.
+func (app *application) createSessionErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var lockedOut *lockedOutError
+	if errors.As(err, &lockedOut) {
+		app.lockedOutResponse(w, r, lockedOut.until)
+		return
+	}
+
+	app.internalServerError(w, r, err)
+}
+
+// recordLoginFailure counts a failed login against the email and the IP. The
+// owner is notified the moment their account gets locked.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *store.User) {
+	ctx := r.Context()
+	policy := app.config.auth.lockout
+
+	if _, err := app.store.LoginThrottles.RecordFailure(ctx, store.ThrottleScopeIP, clientIP(r), policy); err != nil {
+		log.Printf("error recording login failure: %s", err.Error())
+	}
+
+	throttle, err := app.store.LoginThrottles.RecordFailure(ctx, store.ThrottleScopeEmail, email, policy)
+	if err != nil {
+		log.Printf("error recording login failure: %s", err.Error())
+		return
+	}
+
+	if user == nil || throttle.Failures != policy.Threshold {
+		return
+	}
+
+	vars := struct {
+		Username    string
+		LockedUntil string
+		ResetURL    string
+	}{
+		Username:    user.Username,
+		LockedUntil: throttle.LockedUntil.Time.Format(time.RFC1123),
+		ResetURL:    fmt.Sprintf("%s/password/forgot", app.config.frontendURL),
+	}
+
+	app.background(func() {
+		if err := app.mailer.Send(mailer.AccountLockedTemplate, user.Username, user.Email, vars); err != nil {
+			log.Printf("error sending account locked email: %s", err.Error())
+		}
+	})
+}
+
+// clearLoginFailures lifts the lockout of the email after a successful login.
+// The IP is left alone, otherwise any valid account could reset it.
+func (app *application) clearLoginFailures(ctx context.Context, email string) {
+	if err := app.store.LoginThrottles.Clear(ctx, store.ThrottleScopeEmail, email); err != nil {
+		log.Printf("error clearing login failures: %s", err.Error())
+	}
+}
+
+// unlockUserHandler godoc
+//
+// @Summary Unlock a user
+// @Description Lift the lockout caused by failed logins on the user's account
+// @Tags users
+// @Param userID path int true "User ID"
+// @Success 204 {string} string "User unlocked"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/lockout [delete]
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	if err := app.store.LoginThrottles.Clear(r.Context(), store.ThrottleScopeEmail, user.Email); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/mailer"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+type MagicLinkPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// requestMagicLinkHandler godoc
+//
+// @Summary Request a magic link
+// @Description Email a single-use, short-lived sign-in link. Always accepted so that registered emails can't be enumerated.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body MagicLinkPayload true "User email"
+// @Success 202 {string} string "Magic link requested"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 429 {object} ErrorResponse "Too many requests for this email"
+// @Router /v1/authentication/magic-link [post]
+func (app *application) requestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MagicLinkPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// emails are case insensitive, so is their limit
+	if app.magicLinkLimiter.RespondOnLimit(w, r, strings.ToLower(payload.Email)) {
+		return
+	}
+
+	app.background(func() {
+		if err := app.sendMagicLink(context.Background(), payload.Email); err != nil {
+			log.Printf("error sending magic link: %s", err.Error())
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (app *application) sendMagicLink(ctx context.Context, email string) error {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if !user.Verified || !user.MagicLinkEnabled {
+		return nil
+	}
+
+	plainToken, err := generateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	exp := app.config.auth.magicLink.exp
+	if err := app.store.MagicLinks.Create(ctx, user.ID, hashToken(plainToken), exp); err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username string
+		LoginURL string
+		Expiry   string
+	}{
+		Username: user.Username,
+		LoginURL: fmt.Sprintf("%s/v1/authentication/magic-link/%s", app.config.apiURL, plainToken),
+		Expiry:   exp.String(),
+	}
+
+	return app.mailer.Send(mailer.MagicLinkTemplate, user.Username, user.Email, vars)
+}
+
+// magicLinkLoginHandler godoc
+//
+// @Summary Sign in with a magic link
+// @Description Exchange a magic link token for access and refresh tokens
+// @Tags authentication
+// @Produce json
+// @Param token path string true "Magic link token"
+// @Success 200 {object} TokenResponse "Access and refresh tokens, or an MFAChallengeResponse when two-factor authentication is enabled"
+// @Failure 403 {object} ErrorResponse "Magic links are disabled for this user"
+// @Failure 404 {object} ErrorResponse "Unknown token"
+// @Failure 410 {object} ErrorResponse "Expired token"
+// @Failure 429 {object} ErrorResponse "Login locked out"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/magic-link/{token} [get]
+func (app *application) magicLinkLoginHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	ctx := r.Context()
+
+	userID, err := app.store.MagicLinks.Consume(ctx, hashToken(token))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrTokenExpired:
+			app.goneResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// the user may have opted out after the link was sent
+	if !user.MagicLinkEnabled {
+		app.forbiddenResponse(w, r, errors.New("magic links are disabled for this user"))
+		return
+	}
+
+	twoFactor, err := app.twoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if twoFactor {
+		challenge, err := app.createMFAChallenge(ctx, user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		app.recordLoginEvent(w, r, user, store.LoginMethodMagicLink, store.LoginOutcomeChallenged)
+
+		if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	session, err := app.createSession(r, user)
+	if err != nil {
+		app.createSessionErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordLoginEvent(w, r, user, store.LoginMethodMagicLink, store.LoginOutcomeSuccess)
+
+	if err := app.jsonResponse(w, http.StatusOK, session); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -1,17 +1,36 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/go-chi/httprate"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/ssanjose/PingU/internal/auth"
 	"github.com/ssanjose/PingU/internal/db"
 	"github.com/ssanjose/PingU/internal/env"
+	"github.com/ssanjose/PingU/internal/mailer"
 	"github.com/ssanjose/PingU/internal/store"
 )
 
 const version = "0.0.1"
 
 func main() {
+	// argon2 panics on some parameters, so they are checked before any
+	// password is hashed
+	argon2, err := store.NewArgon2Params(
+		env.GetInt("ARGON2_MEMORY", 64*1024), // 64 MiB
+		env.GetInt("ARGON2_ITERATIONS", 3),
+		env.GetInt("ARGON2_PARALLELISM", 2),
+	)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	cfg := config{
 		addr: env.GetString("ADDR", ":8080"),
 		db: dbConfig{
@@ -20,12 +39,99 @@ func main() {
 			maxIdleConns: env.GetInt("DB_MAX_IDLE_CONNS", 30),
 			maxIdleTime:  env.GetString("DB_MAX_IDLE_TIME", "15m"),
 		},
-		env: env.GetString("ENV", "development"),
+		env:         env.GetString("ENV", "development"),
+		apiURL:      env.GetString("API_URL", "http://localhost:8080"),
+		frontendURL: env.GetString("FRONTEND_URL", "http://localhost:3000"),
 		mail: mailConfig{
-			exp: time.Hour * 24 * 3, // 3 days
+			exp:       time.Hour * 24 * 3, // 3 days
+			fromEmail: env.GetString("FROM_EMAIL", "no-reply@pingu.local"),
+			outboxDir: env.GetString("MAIL_OUTBOX_DIR", ""),
+			smtp: smtpConfig{
+				host:     env.GetString("SMTP_HOST", ""),
+				port:     env.GetInt("SMTP_PORT", 587),
+				username: env.GetString("SMTP_USERNAME", ""),
+				password: env.GetString("SMTP_PASSWORD", ""),
+			},
+		},
+		auth: authConfig{
+			token: tokenConfig{
+				exp: time.Minute * 15,
+				iss: "pingu",
+			},
+			signingKeys: signingKeyConfig{
+				dir:        env.GetString("JWT_KEYS_DIR", ""),
+				signingKID: env.GetString("JWT_SIGNING_KID", ""),
+				algorithm:  env.GetString("JWT_SIGNING_ALG", auth.AlgEdDSA),
+				rotation: store.KeyRotationPolicy{
+					Every:       env.GetDuration("JWT_KEY_ROTATION_INTERVAL", time.Hour*24*30),
+					Propagation: signingKeyRefreshInterval * 2,
+					Grace:       env.GetDuration("JWT_KEY_GRACE_PERIOD", time.Hour),
+				},
+			},
+			resetExp:   time.Hour,           // 1 hour
+			emailExp:   time.Hour * 24,      // 1 day
+			refreshExp: time.Hour * 24 * 30, // 30 days
+			apiKey: apiKeyConfig{
+				requestsPerMinute: env.GetInt("API_KEY_REQUESTS_PER_MINUTE", 30),
+			},
+			oidc: loadOIDCConfig(),
+			webAuthn: webAuthnConfig{
+				rpID:            env.GetString("WEBAUTHN_RP_ID", "localhost"),
+				rpDisplayName:   "PingU",
+				rpOrigins:       strings.Split(env.GetString("WEBAUTHN_RP_ORIGINS", "http://localhost:3000"), ","),
+				loginsPerMinute: env.GetInt("WEBAUTHN_LOGINS_PER_MINUTE", 10),
+			},
+			argon2: argon2,
+			resendActivation: resendActivationConfig{
+				requestsPerHour: env.GetInt("RESEND_ACTIVATION_REQUESTS_PER_HOUR", 3),
+			},
+			magicLink: magicLinkConfig{
+				enabled:         env.GetBool("MAGIC_LINK_ENABLED", true),
+				exp:             time.Minute * 15,
+				requestsPerHour: env.GetInt("MAGIC_LINK_REQUESTS_PER_HOUR", 5),
+			},
+			lockout: store.LockoutPolicy{
+				Threshold:   env.GetInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+				BaseLockout: env.GetDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+				MaxLockout:  env.GetDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+				Window:      env.GetDuration("LOGIN_LOCKOUT_WINDOW", time.Minute*15),
+			},
+		},
+		validation: validationConfig{
+			reservedUsernames: strings.Split(env.GetString(
+				"RESERVED_USERNAMES",
+				"admin,administrator,root,system,support,help,security,staff,moderator,pingu,api,www,mail,null,undefined",
+			), ","),
+			minPasswordScore:      env.GetInt("PASSWORD_MIN_STRENGTH", 2), // zxcvbn score, 0 to 4
+			breachedPasswordsFile: env.GetString("BREACHED_PASSWORDS_FILE", ""),
+		},
+		partner: partnerConfig{
+			requestExp:         time.Hour * 24 * 7, // 7 days
+			inviteExp:          env.GetDuration("PARTNER_INVITE_EXP", time.Hour*24),
+			redeemsPerHour:     env.GetInt("PARTNER_INVITE_REDEEMS_PER_HOUR", 10),
+			emailInviteExp:     time.Hour * 24 * 14, // 14 days
+			emailInvitesPerDay: env.GetInt("PARTNER_EMAIL_INVITES_PER_DAY", 10),
+		},
+		janitor: janitorConfig{
+			interval:        env.GetDuration("JANITOR_INTERVAL", time.Hour),
+			unverifiedGrace: env.GetDuration("JANITOR_UNVERIFIED_GRACE", time.Hour*24*7), // 7 days
+			batchSize:       env.GetInt("JANITOR_BATCH_SIZE", 500),
 		},
 	}
 
+	if err := cfg.janitor.validate(); err != nil {
+		log.Panic(err)
+	}
+
+	validation, err := newValidationRules(cfg.validation)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if err := validation.register(Validate); err != nil {
+		log.Panic(err)
+	}
+
 	db, err := db.New(
 		cfg.db.addr,
 		cfg.db.maxOpenConns,
@@ -41,11 +147,94 @@ func main() {
 
 	store := store.NewStorage(db)
 
+	// Fall back to the dev mailer, which never delivers, unless SMTP is configured.
+	var mail mailer.Mailer = mailer.NewDevMailer(cfg.mail.fromEmail, cfg.mail.outboxDir)
+	if cfg.mail.smtp.host != "" {
+		mail = mailer.NewSMTPMailer(
+			cfg.mail.fromEmail,
+			cfg.mail.smtp.host,
+			cfg.mail.smtp.port,
+			cfg.mail.smtp.username,
+			cfg.mail.smtp.password,
+		)
+	}
+
+	keyRing := auth.NewKeyRing(
+		cfg.auth.token.iss,
+		cfg.auth.token.iss,
+		cfg.auth.signingKeys.rotation.Propagation,
+	)
+
+	oidcProviders := make(map[string]*auth.OIDCProvider)
+	for _, p := range cfg.auth.oidc {
+		redirectURL := fmt.Sprintf("%s/v1/authentication/oidc/%s/callback", cfg.apiURL, p.name)
+		oidcProviders[p.name] = auth.NewOIDCProvider(p.name, p.issuer, p.clientID, p.clientSecret, redirectURL)
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.auth.webAuthn.rpID,
+		RPDisplayName: cfg.auth.webAuthn.rpDisplayName,
+		RPOrigins:     cfg.auth.webAuthn.rpOrigins,
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := &application{
-		config: cfg,
-		store:  store,
+		config:                    cfg,
+		store:                     store,
+		authenticator:             keyRing,
+		keyRing:                   keyRing,
+		mailer:                    mail,
+		apiKeyLimiter:             httprate.NewRateLimiter(cfg.auth.apiKey.requestsPerMinute, time.Minute),
+		magicLinkLimiter:          httprate.NewRateLimiter(cfg.auth.magicLink.requestsPerHour, time.Hour),
+		resendActivationLimiter:   httprate.NewRateLimiter(cfg.auth.resendActivation.requestsPerHour, time.Hour),
+		partnerInviteLimiter:      httprate.NewRateLimiter(cfg.partner.redeemsPerHour, time.Hour),
+		partnerEmailInviteLimiter: httprate.NewRateLimiter(cfg.partner.emailInvitesPerDay, time.Hour*24),
+		oidcProviders:             oidcProviders,
+		webAuthnLoginLimiter:      httprate.NewRateLimiter(cfg.auth.webAuthn.loginsPerMinute, time.Minute),
+		webAuthn:                  webAuthn,
+		validation:                validation,
 	}
 
+	// database keys are rotated by every instance, the first rotation creates
+	// the initial key
+	if cfg.auth.signingKeys.dir != "" {
+		err = app.loadSigningKeys(context.Background())
+	} else {
+		err = app.rotateSigningKeys(context.Background())
+		app.background(app.runSigningKeyRotation)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	app.background(app.runJanitor)
+
 	mux := app.mount()
 	log.Fatal(app.run(mux))
 }
+
+// loadOIDCConfig reads the issuers listed in OIDC_PROVIDERS, e.g. "google,mock".
+// Each provider is configured through OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID
+// and OIDC_<NAME>_CLIENT_SECRET.
+func loadOIDCConfig() []oidcConfig {
+	var providers []oidcConfig
+
+	for _, name := range strings.Split(env.GetString("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, oidcConfig{
+			name:         name,
+			issuer:       env.GetString(prefix+"ISSUER", ""),
+			clientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			clientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+		})
+	}
+
+	return providers
+}
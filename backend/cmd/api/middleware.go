@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const authUserCtx userKey = "authUser"
+
+// AuthTokenMiddleware validates the bearer token, or the API key, of the
+// request and puts the authenticated user on the request context.
+func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+			app.authenticateAPIKey(w, r, next, apiKey)
+			return
+		}
+
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			app.unauthorizedErrorResponse(w, r, errors.New("authorization header is missing"))
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			app.unauthorizedErrorResponse(w, r, errors.New("authorization header is malformed"))
+			return
+		}
+
+		jwtToken, err := app.authenticator.ValidateToken(parts[1])
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+
+		sub, err := jwtToken.Claims.GetSubject()
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+
+		userID, err := strconv.ParseInt(sub, 10, 64)
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+
+		user, err := app.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				app.unauthorizedErrorResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, authUserCtx, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// checkUserOwnership only lets the request through if the {userID} in the
+// path belongs to the authenticated user.
+func (app *application) checkUserOwnership(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authUser := getAuthUserFromCtx(r)
+
+		idParam := chi.URLParam(r, "userID")
+		id, err := strconv.ParseInt(idParam, 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if authUser == nil || authUser.ID != id {
+			app.forbiddenResponse(w, r, fmt.Errorf("user cannot act on behalf of user %d", id))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getAuthUserFromCtx(r *http.Request) *store.User {
+	user, _ := r.Context().Value(authUserCtx).(*store.User)
+	return user
+}
+
+// checkPermission lets the owner of the {userID} account through, as well as
+// any user whose role is at least as high as requiredRole. The account is only
+// loaded after the check, so others can't tell which user IDs exist.
+func (app *application) checkPermission(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authUser := getAuthUserFromCtx(r)
+		next := app.userContextMiddleware(next)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if authUser.ID == id {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, err := app.checkRolePrecedence(r.Context(), authUser, requiredRole)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.forbiddenResponse(w, r, fmt.Errorf("user %d lacks the %s role", authUser.ID, requiredRole))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
+	role, err := app.store.Roles.GetByName(ctx, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role.Level >= role.Level, nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/auth"
+	"github.com/ssanjose/PingU/internal/store"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcCookieExp  = time.Minute * 10
+	reauthTokenExp = time.Minute * 5
+
+	oidcModeLogin  = "login"
+	oidcModeReauth = "reauth"
+
+	// oidcUsernameMaxBase leaves room for the "_1234" suffix of a taken
+	// username within the 35 characters a username may have
+	oidcUsernameMaxBase  = 30
+	oidcFallbackUsername = "user"
+)
+
+type ReauthTokenResponse struct {
+	ReauthToken string    `json:"reauth_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// oidcStartHandler godoc
+//
+// @Summary Start an OpenID Connect sign in
+// @Description Redirect to the issuer's consent page. With reauth, signed in users who have no password prove their identity again before sensitive changes.
+// @Tags authentication
+// @Param provider path string true "Provider name"
+// @Param reauth query bool false "Reauthenticate instead of signing in"
+// @Success 302 {string} string "Redirect to the issuer"
+// @Failure 404 {object} ErrorResponse "Unknown provider"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/oidc/{provider}/start [get]
+func (app *application) oidcStartHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown oidc provider"))
+		return
+	}
+
+	state, err := generateSecureToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	nonce, err := generateSecureToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	url, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the browser carries state, nonce and verifier to the callback
+	mode := oidcModeLogin
+	if r.URL.Query().Get("reauth") == "true" {
+		mode = oidcModeReauth
+	}
+
+	value := strings.Join([]string{state, nonce, verifier, mode}, ".")
+	http.SetCookie(w, app.oidcCookie(provider, value, int(oidcCookieExp.Seconds())))
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcCallbackHandler godoc
+//
+// @Summary Finish an OpenID Connect sign in
+// @Description Validate the issuer's response, registering the user on their first sign in. Users with two-factor authentication get a challenge instead of tokens, a reauthentication gets a reauthentication token.
+// @Tags authentication
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} TokenResponse "Access and refresh tokens, an MFAChallengeResponse, or a ReauthTokenResponse"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid sign in"
+// @Failure 409 {object} ErrorResponse "Email already registered"
+// @Failure 429 {object} ErrorResponse "Login locked out"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/oidc/{provider}/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown oidc provider"))
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName(provider))
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	// the sign in attempt is consumed whatever its outcome
+	http.SetCookie(w, app.oidcCookie(provider, "", -1))
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 4 {
+		app.unauthorizedErrorResponse(w, r, errors.New("malformed oidc cookie"))
+		return
+	}
+	state, nonce, verifier, mode := parts[0], parts[1], parts[2], parts[3]
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("oidc provider error: %s", errCode))
+		return
+	}
+
+	if query.Get("state") != state {
+		app.unauthorizedErrorResponse(w, r, errors.New("oidc state does not match"))
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		app.badRequestResponse(w, r, errors.New("missing authorization code"))
+		return
+	}
+
+	ctx := r.Context()
+
+	claims, err := provider.Exchange(ctx, code, nonce, verifier)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	var user *store.User
+
+	identity, err := app.store.Identities.GetByProviderSubject(ctx, provider.Name, claims.Subject)
+
+	if mode == oidcModeReauth {
+		app.oidcReauthenticate(w, r, identity, err)
+		return
+	}
+
+	switch err {
+	case nil:
+		user, err = app.store.Users.GetByID(ctx, identity.UserID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	case store.ErrNotFound:
+		user, err = app.registerOIDCUser(r, provider, claims)
+		if err != nil {
+			switch err {
+			case errOIDCEmailNotVerified:
+				app.forbiddenResponse(w, r, err)
+			case store.ErrDuplicateEmail:
+				app.conflictResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the issuer only replaces the password, not the second factor
+	twoFactor, err := app.twoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if twoFactor {
+		challenge, err := app.createMFAChallenge(ctx, user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		app.recordLoginEvent(w, r, user, store.LoginMethodOIDC, store.LoginOutcomeChallenged)
+
+		if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.createSession(r, user)
+	if err != nil {
+		app.createSessionErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordLoginEvent(w, r, user, store.LoginMethodOIDC, store.LoginOutcomeSuccess)
+
+	if err := app.jsonResponse(w, http.StatusOK, token); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// oidcReauthenticate issues a short-lived token proving the user behind an
+// existing identity just signed in with the issuer again. Users created
+// through an issuer have no password they know, so they present this token
+// instead.
+func (app *application) oidcReauthenticate(w http.ResponseWriter, r *http.Request, identity *store.Identity, err error) {
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, errors.New("identity is not linked to a user"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	plainToken, err := generateSecureToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.ReauthTokens.Create(r.Context(), identity.UserID, hashToken(plainToken), reauthTokenExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := ReauthTokenResponse{
+		ReauthToken: plainToken,
+		ExpiresAt:   time.Now().Add(reauthTokenExp),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+var errOIDCEmailNotVerified = errors.New("the identity provider has not verified this email")
+
+// registerOIDCUser creates a verified user linked to the external identity.
+func (app *application) registerOIDCUser(r *http.Request, provider *auth.OIDCProvider, claims *auth.OIDCClaims) (*store.User, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errOIDCEmailNotVerified
+	}
+
+	// external users sign in through their issuer, so they get a password
+	// nobody knows
+	randomPassword, err := generateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+
+	base := oidcUsername(claims)
+
+	const maxAttempts = 3
+	for i := 0; ; i++ {
+		user := &store.User{
+			Username: base,
+			Email:    claims.Email,
+		}
+
+		// only suffix the username once the plain one is taken. Digits keep
+		// it within the script of the base.
+		if i > 0 {
+			n, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return nil, err
+			}
+			user.Username = fmt.Sprintf("%s_%04d", base, n.Int64())
+		}
+
+		if err := user.Password.Set(randomPassword, app.config.auth.argon2); err != nil {
+			return nil, err
+		}
+
+		identity := &store.Identity{
+			Provider: provider.Name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}
+
+		err := app.store.Users.CreateWithIdentity(r.Context(), user, identity)
+		if err == store.ErrDuplicateUsername && i < maxAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return app.store.Users.GetByID(r.Context(), user.ID)
+	}
+}
+
+// oidcUsername derives the username of a new user from their preferred
+// username, or the local part of their email. It has to pass the same checks
+// as a username picked on registration, and "user" is used when it doesn't.
+func oidcUsername(claims *auth.OIDCClaims) string {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = normalizeUsername(base)
+
+	// leave room for a suffix, without cutting a character in half
+	if runes := []rune(base); len(runes) > oidcUsernameMaxBase {
+		base = string(runes[:oidcUsernameMaxBase])
+	}
+	base = strings.TrimRightFunc(base, isUsernameSeparator)
+
+	if err := Validate.Var(base, "required,max=35,username,notreserved"); err != nil {
+		return oidcFallbackUsername
+	}
+
+	return base
+}
+
+func oidcCookieName(provider *auth.OIDCProvider) string {
+	return "pingu_oidc_" + provider.Name
+}
+
+// oidcCookie is the cookie carrying a sign in attempt, it is cleared with a
+// negative maxAge. Setting and clearing must use the same attributes.
+func (app *application) oidcCookie(provider *auth.OIDCProvider, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcCookieName(provider),
+		Value:    value,
+		Path:     "/v1/authentication/oidc/" + provider.Name,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   app.config.env != "development",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/mailer"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+type CreatePartnerEmailInvitePayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// createPartnerEmailInviteHandler godoc
+//
+// @Summary Invite a partner by email
+// @Description Email a registration invite to an address without an account. Once it registers and activates the account, the new user is partnered with the inviter. Addresses that already have an account get the same response but no email, so registered emails can't be enumerated.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param payload body CreatePartnerEmailInvitePayload true "Email to invite"
+// @Success 201 {object} store.PartnerEmailInvite "Partner invite sent"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 409 {object} ErrorResponse "User is already partnered"
+// @Failure 429 {object} ErrorResponse "Too many invites"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-email-invites [post]
+func (app *application) createPartnerEmailInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload CreatePartnerEmailInvitePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if strings.EqualFold(payload.Email, user.Email) {
+		app.badRequestResponse(w, r, errors.New("users cannot partner with themselves"))
+		return
+	}
+
+	if user.PartnerID.Valid {
+		app.conflictResponse(w, r, store.ErrAlreadyPartnered)
+		return
+	}
+
+	// every invite may send an email, and each answer says something about
+	// the address, so they are limited per inviter before anything is looked up
+	if app.partnerEmailInviteLimiter.RespondOnLimit(w, r, strconv.FormatInt(user.ID, 10)) {
+		return
+	}
+
+	invite := &store.PartnerEmailInvite{
+		InviterID: user.ID,
+		Email:     payload.Email,
+		Expiry:    time.Now().Add(app.config.partner.emailInviteExp),
+	}
+
+	// the invite is stored either way, it only ever pairs an account that
+	// activates after it was sent
+	if err := app.store.PartnerEmailInvites.Create(r.Context(), invite); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// whether the address has an account is only looked up off the request,
+	// so the response doesn't tell
+	app.background(func() {
+		if err := app.sendPartnerEmailInvite(context.Background(), user, invite); err != nil {
+			log.Printf("error sending partner invite: %s", err.Error())
+		}
+	})
+
+	if err := app.jsonResponse(w, http.StatusCreated, invite); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// sendPartnerEmailInvite emails the invite to an address without an account.
+// Registered users are asked through a partner request instead, so they get
+// nothing.
+func (app *application) sendPartnerEmailInvite(ctx context.Context, inviter *store.User, invite *store.PartnerEmailInvite) error {
+	_, err := app.store.Users.GetByEmail(ctx, invite.Email)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+	default:
+		return err
+	}
+
+	vars := struct {
+		InviterUsername string
+		RegisterURL     string
+		Expiry          string
+	}{
+		InviterUsername: inviter.Username,
+		RegisterURL:     fmt.Sprintf("%s/register?email=%s", app.config.frontendURL, url.QueryEscape(invite.Email)),
+		Expiry:          app.config.partner.emailInviteExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.PartnerInviteTemplate, "", invite.Email, vars); err != nil {
+		// an invite nobody received can't be accepted
+		if err := app.store.PartnerEmailInvites.Delete(ctx, inviter.ID, invite.ID); err != nil {
+			log.Printf("error deleting partner invite: %s", err.Error())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// getPartnerEmailInvitesHandler godoc
+//
+// @Summary List partner email invites
+// @Description List the user's email invites that are still pending
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 200 {array} store.PartnerEmailInvite "Pending partner email invites"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-email-invites [get]
+func (app *application) getPartnerEmailInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	invites, err := app.store.PartnerEmailInvites.GetByInviterID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, invites); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// deletePartnerEmailInviteHandler godoc
+//
+// @Summary Cancel a partner email invite
+// @Description Withdraw an email invite, the invited address is no longer paired on signup
+// @Tags users
+// @Param userID path int true "User ID"
+// @Param inviteID path int true "Partner email invite ID"
+// @Success 204 {string} string "Partner email invite cancelled"
+// @Failure 404 {object} ErrorResponse "Partner email invite not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-email-invites/{inviteID} [delete]
+func (app *application) deletePartnerEmailInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "inviteID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.PartnerEmailInvites.Delete(r.Context(), user.ID, id); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const (
+	// partnerInviteAlphabet leaves out letters and digits that are easily
+	// confused when read aloud or typed, like 0 and O, or 1, I and L.
+	partnerInviteAlphabet   = "ABCDEFGHJKMNPQRSTVWXYZ23456789"
+	partnerInviteCodeLength = 8
+
+	partnerInviteQRSize = 256
+)
+
+var errInvalidPartnerInviteCode = errors.New("invalid partner invite code")
+
+type PartnerInviteResponse struct {
+	Code      string    `json:"code"`
+	URI       string    `json:"uri"`
+	ExpiresAt time.Time `json:"expires_at"`
+	QRPNG     []byte    `json:"qr_png"`
+	QRSVG     string    `json:"qr_svg"`
+}
+
+// createPartnerInviteHandler godoc
+//
+// @Summary Create a partner invite code
+// @Description Mint a short, single-use code another user can redeem to become the user's partner. The code comes with a pingu:// pairing URI and a QR code of it, as a base64 PNG and as SVG. Every other code of the user is revoked once one is redeemed.
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 201 {object} PartnerInviteResponse "Partner invite created"
+// @Failure 409 {object} ErrorResponse "User is already partnered"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-invites [post]
+func (app *application) createPartnerInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	if user.PartnerID.Valid {
+		app.conflictResponse(w, r, store.ErrAlreadyPartnered)
+		return
+	}
+
+	code, err := generatePartnerInviteCode()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	exp := app.config.partner.inviteExp
+	if err := app.store.PartnerInvites.Create(r.Context(), user.ID, hashToken(code), exp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	uri := "pingu://pair?code=" + url.QueryEscape(formatPartnerInviteCode(code))
+
+	qr, err := qrcode.New(uri, qrcode.Medium)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	png, err := qr.PNG(partnerInviteQRSize)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := PartnerInviteResponse{
+		Code:      formatPartnerInviteCode(code),
+		URI:       uri,
+		ExpiresAt: time.Now().Add(exp),
+		QRPNG:     png,
+		QRSVG:     qrSVG(qr.Bitmap()),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, res); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// redeemPartnerInviteHandler godoc
+//
+// @Summary Redeem a partner invite code
+// @Description Partner the authenticated user with the user who created the code. Dashes, spaces and case in the code are ignored.
+// @Tags users
+// @Param code path string true "Partner invite code"
+// @Success 204 {string} string "Partner invite redeemed"
+// @Failure 400 {object} ErrorResponse "Malformed code, or the user's own code"
+// @Failure 404 {object} ErrorResponse "Partner invite not found"
+// @Failure 409 {object} ErrorResponse "Either user is already partnered"
+// @Failure 410 {object} ErrorResponse "Partner invite expired"
+// @Failure 429 {object} ErrorResponse "Too many attempts"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/partner-invites/{code}/redeem [post]
+func (app *application) redeemPartnerInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	// codes are short, so guesses are limited per user
+	if app.partnerInviteLimiter.RespondOnLimit(w, r, strconv.FormatInt(user.ID, 10)) {
+		return
+	}
+
+	code, err := normalizePartnerInviteCode(chi.URLParam(r, "code"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.store.PartnerInvites.Redeem(r.Context(), hashToken(code), user.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrSelfInvite:
+			app.badRequestResponse(w, r, err)
+		case store.ErrAlreadyPartnered:
+			app.conflictResponse(w, r, err)
+		case store.ErrTokenExpired:
+			app.goneResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func generatePartnerInviteCode() (string, error) {
+	max := big.NewInt(int64(len(partnerInviteAlphabet)))
+
+	code := make([]byte, partnerInviteCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = partnerInviteAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// formatPartnerInviteCode splits the code in two halves, e.g. "ABCD-2345".
+func formatPartnerInviteCode(code string) string {
+	half := len(code) / 2
+	return code[:half] + "-" + code[half:]
+}
+
+// normalizePartnerInviteCode undoes formatPartnerInviteCode and whatever
+// the user did while typing the code in.
+func normalizePartnerInviteCode(code string) (string, error) {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	if len(code) != partnerInviteCodeLength {
+		return "", errInvalidPartnerInviteCode
+	}
+
+	for _, c := range code {
+		if !strings.ContainsRune(partnerInviteAlphabet, c) {
+			return "", errInvalidPartnerInviteCode
+		}
+	}
+
+	return code, nil
+}
+
+// qrSVG draws a QR bitmap as an SVG with one unit per module, so that it
+// scales to any size.
+func qrSVG(bitmap [][]bool) string {
+	var b bytes.Buffer
+
+	size := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	b.WriteString(`"/></svg>`)
+
+	return b.String()
+}
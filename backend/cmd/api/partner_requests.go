@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+type CreatePartnerRequestPayload struct {
+	RecipientID int64 `json:"recipient_id" validate:"required"`
+}
+
+// createPartnerRequestHandler godoc
+//
+// @Summary Send a partner request
+// @Description Ask another user to become the user's partner
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param payload body CreatePartnerRequestPayload true "Recipient"
+// @Success 201 {object} store.PartnerRequest "Partner request sent"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 404 {object} ErrorResponse "Recipient not found"
+// @Failure 409 {object} ErrorResponse "Either user is already partnered, or the request is already pending"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-requests [post]
+func (app *application) createPartnerRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload CreatePartnerRequestPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.sendPartnerRequest(w, r, user, payload.RecipientID)
+}
+
+// setUserPartnerHandler godoc
+//
+// @Summary Send a partner request
+// @Description Ask another user to become the user's partner. Kept for older clients, it no longer partners the users right away but sends the same request as POST /v1/users/{userID}/partner-requests.
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param partnerID path int true "Recipient ID"
+// @Success 201 {object} store.PartnerRequest "Partner request sent"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 404 {object} ErrorResponse "Recipient not found"
+// @Failure 409 {object} ErrorResponse "Either user is already partnered, or the request is already pending"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Deprecated
+// @Router /v1/users/{userID}/partner/{partnerID} [put]
+func (app *application) setUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	partnerID, err := strconv.ParseInt(chi.URLParam(r, "partnerID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.sendPartnerRequest(w, r, user, partnerID)
+}
+
+// sendPartnerRequest asks the recipient to become the user's partner and
+// responds with the pending request.
+func (app *application) sendPartnerRequest(w http.ResponseWriter, r *http.Request, user *store.User, recipientID int64) {
+	if recipientID == user.ID {
+		app.badRequestResponse(w, r, errors.New("users cannot partner with themselves"))
+		return
+	}
+
+	ctx := r.Context()
+
+	recipient, err := app.store.Users.GetByID(ctx, recipientID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.PartnerID.Valid || recipient.PartnerID.Valid {
+		app.conflictResponse(w, r, store.ErrAlreadyPartnered)
+		return
+	}
+
+	request := &store.PartnerRequest{
+		SenderID:    user.ID,
+		RecipientID: recipient.ID,
+		Expiry:      time.Now().Add(app.config.partner.requestExp),
+	}
+
+	if err := app.store.PartnerRequests.Create(ctx, request); err != nil {
+		switch err {
+		case store.ErrDuplicatePartnerRequest:
+			app.conflictResponse(w, r, err)
+		case store.ErrNotFound:
+			// a block looks like an unknown recipient
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, request); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type PartnerRequestsResponse struct {
+	Incoming []store.PartnerRequest `json:"incoming"`
+	Outgoing []store.PartnerRequest `json:"outgoing"`
+}
+
+// getPartnerRequestsHandler godoc
+//
+// @Summary List partner requests
+// @Description List the pending partner requests the user received and sent
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 200 {object} PartnerRequestsResponse "Pending partner requests"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-requests [get]
+func (app *application) getPartnerRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	incoming, outgoing, err := app.store.PartnerRequests.GetPending(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := PartnerRequestsResponse{Incoming: incoming, Outgoing: outgoing}
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// acceptPartnerRequestHandler godoc
+//
+// @Summary Accept a partner request
+// @Description Partner the user with the sender of a request they received
+// @Tags users
+// @Param userID path int true "User ID"
+// @Param requestID path int true "Partner request ID"
+// @Success 204 {string} string "Partner request accepted"
+// @Failure 404 {object} ErrorResponse "Partner request not found"
+// @Failure 409 {object} ErrorResponse "Request no longer pending, or either user is already partnered"
+// @Failure 410 {object} ErrorResponse "Partner request expired"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-requests/{requestID}/accept [put]
+func (app *application) acceptPartnerRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.respondToPartnerRequest(w, r, app.store.PartnerRequests.Accept)
+}
+
+// declinePartnerRequestHandler godoc
+//
+// @Summary Decline a partner request
+// @Description Decline a partner request the user received
+// @Tags users
+// @Param userID path int true "User ID"
+// @Param requestID path int true "Partner request ID"
+// @Success 204 {string} string "Partner request declined"
+// @Failure 404 {object} ErrorResponse "Partner request not found"
+// @Failure 409 {object} ErrorResponse "Request no longer pending"
+// @Failure 410 {object} ErrorResponse "Partner request expired"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-requests/{requestID}/decline [put]
+func (app *application) declinePartnerRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.respondToPartnerRequest(w, r, app.store.PartnerRequests.Decline)
+}
+
+// cancelPartnerRequestHandler godoc
+//
+// @Summary Cancel a partner request
+// @Description Withdraw a partner request the user sent
+// @Tags users
+// @Param userID path int true "User ID"
+// @Param requestID path int true "Partner request ID"
+// @Success 204 {string} string "Partner request cancelled"
+// @Failure 404 {object} ErrorResponse "Partner request not found"
+// @Failure 409 {object} ErrorResponse "Request no longer pending"
+// @Failure 410 {object} ErrorResponse "Partner request expired"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partner-requests/{requestID}/cancel [put]
+func (app *application) cancelPartnerRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.respondToPartnerRequest(w, r, app.store.PartnerRequests.Cancel)
+}
+
+func (app *application) respondToPartnerRequest(w http.ResponseWriter, r *http.Request, respond func(ctx context.Context, id, userID int64) error) {
+	user := getUserFromCtx(r)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "requestID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := respond(r.Context(), id, user.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrPartnerRequestClosed, store.ErrAlreadyPartnered:
+			app.conflictResponse(w, r, err)
+		case store.ErrPartnerRequestExpired:
+			app.goneResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+// PartnershipTotals sums the counters of every partnership of the user.
+type PartnershipTotals struct {
+	Partnerships  int   `json:"partnerships"`
+	PingsSent     int64 `json:"pings_sent"`
+	PingsReceived int64 `json:"pings_received"`
+	PongsSent     int64 `json:"pongs_sent"`
+	PongsReceived int64 `json:"pongs_received"`
+}
+
+type PartnershipsResponse struct {
+	Current *store.Partnership  `json:"current"`
+	Past    []store.Partnership `json:"past"`
+	Totals  PartnershipTotals   `json:"totals"`
+}
+
+// getPartnershipsHandler godoc
+//
+// @Summary List partnerships
+// @Description List the user's current and past partnerships, newest first, with ping totals
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 200 {object} PartnershipsResponse "Partnerships"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/partnerships [get]
+func (app *application) getPartnershipsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	partnerships, err := app.store.Partnerships.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := PartnershipsResponse{Past: []store.Partnership{}}
+	for i, p := range partnerships {
+		if !p.EndedAt.Valid {
+			res.Current = &partnerships[i]
+		} else {
+			res.Past = append(res.Past, p)
+		}
+
+		res.Totals.Partnerships++
+		res.Totals.PingsSent += p.PingsSent
+		res.Totals.PingsReceived += p.PingsReceived
+		res.Totals.PongsSent += p.PongsSent
+		res.Totals.PongsReceived += p.PongsReceived
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ssanjose/PingU/internal/mailer"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// forgotPasswordHandler godoc
+//
+// @Summary Request a password reset
+// @Description Email a single-use password reset link. Always accepted so that registered emails can't be enumerated.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body ForgotPasswordPayload true "User email"
+// @Success 202 {string} string "Reset requested"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Router /v1/authentication/password/forgot [post]
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// the lookup and the email happen off the request so the response time
+	// doesn't tell whether the account exists
+	app.background(func() {
+		if err := app.sendPasswordReset(context.Background(), payload.Email); err != nil {
+			log.Printf("error sending password reset: %s", err.Error())
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (app *application) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	plainToken := uuid.New().String()
+
+	err = app.store.PasswordResets.Create(ctx, user.ID, hashToken(plainToken), app.config.auth.resetExp)
+	if err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username string
+		ResetURL string
+		Expiry   string
+	}{
+		Username: user.Username,
+		ResetURL: fmt.Sprintf("%s/password/reset/%s", app.config.frontendURL, plainToken),
+		Expiry:   app.config.auth.resetExp.String(),
+	}
+
+	return app.mailer.Send(mailer.PasswordResetTemplate, user.Username, user.Email, vars)
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6,max=256,password,notbreached"`
+}
+
+// resetPasswordHandler godoc
+//
+// @Summary Reset a password
+// @Description Set a new password with a password reset token
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body ResetPasswordPayload true "Reset token and new password"
+// @Success 204 {string} string "Password reset"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 404 {object} ErrorResponse "Unknown token"
+// @Failure 410 {object} ErrorResponse "Expired token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/password/reset [post]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var user store.User
+	if err := user.Password.Set(payload.Password, app.config.auth.argon2); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	err := app.store.PasswordResets.Reset(r.Context(), hashToken(payload.Token), &user)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrTokenExpired:
+			app.goneResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// whoever knew the old password shouldn't stay signed in
+	if err := app.store.Sessions.RevokeAll(r.Context(), user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mssola/useragent"
+	"github.com/ssanjose/PingU/internal/mailer"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const (
+	securityEventsPageSize    = 20
+	securityEventsMaxPageSize = 100
+
+	// apiKeyEventDedupe keeps every API key request from being recorded as a
+	// login of its own
+	apiKeyEventDedupe = time.Hour
+
+	// deviceCookieName tells browsers apart for as long as they keep the
+	// cookie, which is issued on their first login attempt
+	deviceCookieName   = "pingu_device"
+	deviceCookieMaxAge = 60 * 60 * 24 * 400 // 400 days, the longest browsers keep a cookie
+	maxDeviceIDLength  = 64
+)
+
+// newSecurityEvent describes a login attempt made by the device of the
+// request.
+func (app *application) newSecurityEvent(w http.ResponseWriter, r *http.Request, method, outcome string) *store.SecurityEvent {
+	ua := useragent.New(r.UserAgent())
+	browser, _ := ua.Browser()
+
+	device := "desktop"
+	switch {
+	case ua.Bot():
+		device = "bot"
+	case ua.Mobile():
+		device = "mobile"
+	}
+
+	fingerprint := sha256.Sum256([]byte(app.deviceID(w, r, method)))
+
+	return &store.SecurityEvent{
+		Method:      method,
+		Outcome:     outcome,
+		IP:          clientIP(r),
+		UserAgent:   r.UserAgent(),
+		Browser:     browser,
+		OS:          ua.OS(),
+		Device:      device,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// deviceID identifies the device of the request. Every API key is a device
+// of its own, browsers carry a random ID in a long-lived cookie. A browser
+// without one gets it now, and this attempt counts as coming from a new
+// device.
+func (app *application) deviceID(w http.ResponseWriter, r *http.Request, method string) string {
+	if method == store.LoginMethodAPIKey {
+		return "api_key:" + hashToken(r.Header.Get(apiKeyHeader))
+	}
+
+	if cookie, err := r.Cookie(deviceCookieName); err == nil && cookie.Value != "" && len(cookie.Value) <= maxDeviceIDLength {
+		return "device:" + cookie.Value
+	}
+
+	id, err := generateSecureToken()
+	if err != nil {
+		log.Printf("error generating device id: %s", err.Error())
+		return ""
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   deviceCookieMaxAge,
+		HttpOnly: true,
+		Secure:   app.config.env != "development",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return "device:" + id
+}
+
+// recordLoginEvent adds a login attempt to the user's security events, and
+// alerts the user when a successful login comes from a new device. It has to
+// be called before the response is written, as it may set the device cookie.
+func (app *application) recordLoginEvent(w http.ResponseWriter, r *http.Request, user *store.User, method, outcome string) {
+	event := app.newSecurityEvent(w, r, method, outcome)
+	event.UserID = user.ID
+	event.Email = user.Email
+
+	var dedupe time.Duration
+	if method == store.LoginMethodAPIKey {
+		dedupe = apiKeyEventDedupe
+	}
+
+	// the event is recorded off the request, a login shouldn't wait on it
+	app.background(func() {
+		newDevice, err := app.store.SecurityEvents.Record(context.Background(), event, dedupe)
+		if err != nil {
+			log.Printf("error recording security event: %s", err.Error())
+			return
+		}
+
+		if !newDevice {
+			return
+		}
+
+		vars := struct {
+			Username string
+			Device   string
+			IP       string
+			Time     string
+			ResetURL string
+		}{
+			Username: user.Username,
+			Device:   fmt.Sprintf("%s on %s", event.Browser, event.OS),
+			IP:       event.IP,
+			Time:     event.CreatedAt.Format(time.RFC1123),
+			ResetURL: fmt.Sprintf("%s/password/forgot", app.config.frontendURL),
+		}
+
+		if err := app.mailer.Send(mailer.NewDeviceTemplate, user.Username, user.Email, vars); err != nil {
+			log.Printf("error sending new device email: %s", err.Error())
+		}
+	})
+}
+
+// recordUnknownLoginEvent records a failed login attempt that matched no
+// user, like a password login with an unregistered email, or an unknown API
+// key. email is empty when the attempt didn't name one.
+func (app *application) recordUnknownLoginEvent(w http.ResponseWriter, r *http.Request, email, method string) {
+	event := app.newSecurityEvent(w, r, method, store.LoginOutcomeFailure)
+	event.Email = strings.ToLower(email)
+
+	var dedupe time.Duration
+	if method == store.LoginMethodAPIKey {
+		dedupe = apiKeyEventDedupe
+	}
+
+	app.background(func() {
+		if _, err := app.store.SecurityEvents.Record(context.Background(), event, dedupe); err != nil {
+			log.Printf("error recording security event: %s", err.Error())
+		}
+	})
+}
+
+type SecurityEventsResponse struct {
+	Events     []store.SecurityEvent `json:"events"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// getSecurityEventsHandler godoc
+//
+// @Summary List login history
+// @Description List the login attempts on the user's account, newest first
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} SecurityEventsResponse "A page of security events"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/security/events [get]
+func (app *application) getSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	query := r.URL.Query()
+
+	var before int64
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		before, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before <= 0 {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid cursor %q", cursor))
+			return
+		}
+	}
+
+	limit := securityEventsPageSize
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > securityEventsMaxPageSize {
+			app.badRequestResponse(w, r, fmt.Errorf("limit must be between 1 and %d", securityEventsMaxPageSize))
+			return
+		}
+	}
+
+	// one extra event tells whether there is a next page
+	events, err := app.store.SecurityEvents.GetByUserID(r.Context(), user.ID, before, limit+1)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := SecurityEventsResponse{Events: events}
+	if len(events) > limit {
+		res.Events = events[:limit]
+		res.NextCursor = strconv.FormatInt(res.Events[limit-1].ID, 10)
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+// createSession starts a new session for the user and issues its first pair
+// of access and refresh tokens.
+func (app *application) createSession(r *http.Request, user *store.User) (*TokenResponse, error) {
+	// every way of signing in ends here, so none of them gets around a lockout
+	lockedUntil, err := app.store.LoginThrottles.LockedUntil(r.Context(), user.Email, clientIP(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if !lockedUntil.IsZero() {
+		return nil, &lockedOutError{until: lockedUntil}
+	}
+
+	refreshToken, err := generateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &store.Session{
+		UserID:    user.ID,
+		Token:     hashToken(refreshToken),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		Expiry:    time.Now().Add(app.config.auth.refreshExp),
+	}
+
+	if err := app.store.Sessions.Create(r.Context(), session); err != nil {
+		return nil, err
+	}
+
+	token, err := app.generateAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	token.RefreshToken = refreshToken
+	token.RefreshExpiresAt = session.Expiry
+
+	return token, nil
+}
+
+// generateSecureToken returns a random, URL safe token.
+func generateSecureToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// clientIP returns the IP of the caller, as set by middleware.RealIP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// refreshTokenHandler godoc
+//
+// @Summary Refresh an access token
+// @Description Rotate a refresh token for a new pair of access and refresh tokens
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body RefreshTokenPayload true "Refresh token"
+// @Success 200 {object} TokenResponse "Access and refresh tokens"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid refresh token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/refresh [post]
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	refreshToken, err := generateSecureToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	session := &store.Session{
+		Token:     hashToken(refreshToken),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		Expiry:    time.Now().Add(app.config.auth.refreshExp),
+	}
+
+	err = app.store.Sessions.Rotate(ctx, hashToken(payload.RefreshToken), session)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound, store.ErrTokenExpired, store.ErrSessionRevoked, store.ErrTokenReused:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, session.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.generateAccessToken(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	token.RefreshToken = refreshToken
+	token.RefreshExpiresAt = session.Expiry
+
+	if err := app.jsonResponse(w, http.StatusOK, token); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// logoutUserHandler godoc
+//
+// @Summary Log out a user
+// @Description Revoke the session a refresh token belongs to
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body RefreshTokenPayload true "Refresh token"
+// @Success 204 {string} string "Logged out"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/logout [post]
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Sessions.RevokeByToken(r.Context(), hashToken(payload.RefreshToken)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) getUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	sessions, err := app.store.Sessions.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, sessions); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	if err := app.store.Sessions.RevokeAll(r.Context(), user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) deleteUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	sessionID := chi.URLParam(r, "sessionID")
+	if err := uuid.Validate(sessionID); err != nil {
+		app.notFoundResponse(w, r, errors.New("malformed session id"))
+		return
+	}
+
+	if err := app.store.Sessions.Revoke(r.Context(), user.ID, sessionID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssanjose/PingU/internal/auth"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+// signingKeyRefreshInterval is how often every instance rotates, if due, and
+// reloads the key ring. Rotated keys have to propagate for longer than this.
+const signingKeyRefreshInterval = time.Minute
+
+// loadSigningKeys fills the key ring from the key files when a key directory
+// is configured, and from the database otherwise.
+func (app *application) loadSigningKeys(ctx context.Context) error {
+	cfg := app.config.auth.signingKeys
+
+	if cfg.dir != "" {
+		keys, err := loadSigningKeyFiles(cfg.dir, cfg.signingKID, cfg.rotation.Grace)
+		if err != nil {
+			return err
+		}
+
+		app.keyRing.Set(keys)
+		return nil
+	}
+
+	stored, err := app.store.SigningKeys.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*auth.SigningKey, 0, len(stored))
+	for _, s := range stored {
+		key, err := auth.UnmarshalSigningKey(s.ID, s.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		key.CreatedAt = s.CreatedAt
+		key.RetiresAt = s.RetiresAt.Time
+		key.ExpiresAt = s.ExpiresAt.Time
+		keys = append(keys, key)
+	}
+
+	app.keyRing.Set(keys)
+	return nil
+}
+
+// loadSigningKeyFiles reads every *.pem file of dir, the file name is the key
+// ID. Only signingKID signs, the other keys are kept around for grace to
+// verify tokens they signed before the files were rotated.
+func loadSigningKeyFiles(dir, signingKID string, grace time.Duration) ([]*auth.SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	if signingKID == "" && len(paths) == 1 {
+		signingKID = strings.TrimSuffix(filepath.Base(paths[0]), ".pem")
+	}
+
+	now := time.Now()
+	found := false
+
+	keys := make([]*auth.SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := auth.ParseSigningKey(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.ID == signingKID {
+			found = true
+		} else {
+			key.RetiresAt = now
+			key.ExpiresAt = now.Add(grace)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("signing key %q not found in %s", signingKID, dir)
+	}
+
+	return keys, nil
+}
+
+// rotateSigningKeys replaces the current signing key when it is due, then
+// reloads the key ring so keys rotated by other instances are picked up too.
+func (app *application) rotateSigningKeys(ctx context.Context) error {
+	cfg := app.config.auth.signingKeys
+
+	due, err := app.store.SigningKeys.RotationDue(ctx, cfg.rotation)
+	if err != nil {
+		return err
+	}
+
+	if !due {
+		return app.loadSigningKeys(ctx)
+	}
+
+	key, err := auth.GenerateSigningKey(cfg.algorithm)
+	if err != nil {
+		return err
+	}
+
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return err
+	}
+
+	next := &store.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: der,
+	}
+
+	rotated, err := app.store.SigningKeys.Rotate(ctx, next, cfg.rotation)
+	if err != nil {
+		return err
+	}
+
+	if rotated {
+		log.Printf("rotated signing key, new key %s", next.ID)
+	}
+
+	return app.loadSigningKeys(ctx)
+}
+
+// runSigningKeyRotation rotates and reloads the database keys until the
+// process exits. Key files are rotated by whoever manages them.
+func (app *application) runSigningKeyRotation() {
+	ticker := time.NewTicker(signingKeyRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := app.rotateSigningKeys(context.Background()); err != nil {
+			log.Printf("error rotating signing keys: %s", err.Error())
+		}
+	}
+}
+
+// jwksHandler godoc
+//
+// @Summary Get the token signing keys
+// @Description Publish the public keys that verify PingU access tokens as a JSON Web Key Set
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} auth.JWKS "JSON Web Key Set"
+// @Router /.well-known/jwks.json [get]
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	// keys rotate slowly, but verifiers should notice within a refresh
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(signingKeyRefreshInterval.Seconds())))
+
+	if err := writeJSON(w, http.StatusOK, app.keyRing.JWKS()); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ssanjose/PingU/internal/auth"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const (
+	totpIssuer           = "PingU"
+	recoveryCodeCount    = 10
+	mfaChallengeExp      = time.Minute * 5
+	mfaChallengeAttempts = 5
+)
+
+type MFAChallengeResponse struct {
+	MFARequired    bool      `json:"mfa_required"`
+	ChallengeToken string    `json:"challenge_token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// createMFAChallenge finishes the password step of a login for a user with
+// two-factor authentication enabled.
+func (app *application) createMFAChallenge(ctx context.Context, user *store.User) (*MFAChallengeResponse, error) {
+	plainToken, err := generateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.store.TwoFactor.CreateChallenge(ctx, user.ID, hashToken(plainToken), mfaChallengeExp); err != nil {
+		return nil, err
+	}
+
+	return &MFAChallengeResponse{
+		MFARequired:    true,
+		ChallengeToken: plainToken,
+		ExpiresAt:      time.Now().Add(mfaChallengeExp),
+	}, nil
+}
+
+// twoFactorEnabled reports whether the user has to pass a second factor to
+// sign in.
+func (app *application) twoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	totp, err := app.store.TwoFactor.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return totp.Enabled(), nil
+}
+
+type VerifyTwoFactorPayload struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,max=32"`
+}
+
+// verifyTwoFactorHandler godoc
+//
+// @Summary Finish a two-factor login
+// @Description Exchange the login challenge token and a TOTP or recovery code for access and refresh tokens
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body VerifyTwoFactorPayload true "Challenge token and code"
+// @Success 200 {object} TokenResponse "Access and refresh tokens"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid challenge or code"
+// @Failure 429 {object} ErrorResponse "Login locked out"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/2fa [post]
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	challenge := hashToken(payload.ChallengeToken)
+
+	userID, err := app.store.TwoFactor.AttemptChallenge(ctx, challenge, mfaChallengeAttempts)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired challenge"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.verifySecondFactor(ctx, userID, payload.Code); err != nil {
+		switch err {
+		case errInvalidCredentials:
+			app.recordLoginEvent(w, r, user, store.LoginMethodTwoFactor, store.LoginOutcomeFailure)
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.TwoFactor.DeleteChallenge(ctx, challenge); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	token, err := app.createSession(r, user)
+	if err != nil {
+		app.createSessionErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordLoginEvent(w, r, user, store.LoginMethodTwoFactor, store.LoginOutcomeSuccess)
+
+	if err := app.jsonResponse(w, http.StatusOK, token); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code of the user.
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, code string) error {
+	totp, err := app.store.TwoFactor.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return errInvalidCredentials
+		}
+		return err
+	}
+
+	if !totp.Enabled() {
+		return errInvalidCredentials
+	}
+
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		err := app.store.TwoFactor.UseTOTPStep(ctx, userID, step)
+		if err == store.ErrCodeReused {
+			return errInvalidCredentials
+		}
+		return err
+	}
+
+	err = app.store.TwoFactor.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	if err == store.ErrNotFound {
+		return errInvalidCredentials
+	}
+
+	return err
+}
+
+// reauthenticate checks the password, or a reauthentication token from the
+// user's identity provider, and the second factor of a signed in user before
+// sensitive changes to their two-factor settings.
+func (app *application) reauthenticate(ctx context.Context, user *store.User, payload ReauthenticatePayload) error {
+	if payload.ReauthToken != "" {
+		err := app.store.ReauthTokens.Consume(ctx, hashToken(payload.ReauthToken), user.ID)
+		switch err {
+		case nil:
+		case store.ErrNotFound, store.ErrTokenExpired:
+			return errInvalidCredentials
+		default:
+			return err
+		}
+	} else {
+		withPassword, err := app.store.Users.GetByEmail(ctx, user.Email)
+		if err != nil {
+			return err
+		}
+
+		if err := withPassword.Password.Compare(payload.Password); err != nil {
+			return errInvalidCredentials
+		}
+	}
+
+	return app.verifySecondFactor(ctx, user.ID, payload.Code)
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// enrollTOTPHandler godoc
+//
+// @Summary Enroll a TOTP authenticator
+// @Description Generate a TOTP secret. Two-factor authentication is only enabled once a first code is confirmed.
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 201 {object} TOTPEnrollment "Secret and otpauth URI"
+// @Failure 409 {object} ErrorResponse "Already enabled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/2fa/totp [post]
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.EnrollTOTP(r.Context(), user.ID, secret); err != nil {
+		switch err {
+		case store.ErrTwoFactorEnabled:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	enrollment := &TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type ConfirmTOTPPayload struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// confirmTOTPHandler godoc
+//
+// @Summary Confirm a TOTP authenticator
+// @Description Enable two-factor authentication with a first code and receive single-use recovery codes
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param payload body ConfirmTOTPPayload true "First code"
+// @Success 200 {object} RecoveryCodesResponse "Recovery codes"
+// @Failure 400 {object} ErrorResponse "Invalid code"
+// @Failure 404 {object} ErrorResponse "No enrollment"
+// @Failure 409 {object} ErrorResponse "Already enabled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/2fa/totp/confirm [post]
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload ConfirmTOTPPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	totp, err := app.store.TwoFactor.GetTOTP(ctx, user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if totp.Enabled() {
+		app.conflictResponse(w, r, store.ErrTwoFactorEnabled)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, payload.Code, time.Now())
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("invalid code"))
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.ConfirmTOTP(ctx, user.ID, step, hashes); err != nil {
+		switch err {
+		case store.ErrTwoFactorEnabled:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// ReauthenticatePayload proves the user with their password, or with the
+// reauth_token of an OIDC reauthentication when they signed up through an
+// identity provider.
+type ReauthenticatePayload struct {
+	Password    string `json:"password" validate:"required_without=ReauthToken,max=256"`
+	ReauthToken string `json:"reauth_token" validate:"required_without=Password,max=64"`
+	Code        string `json:"code" validate:"required,max=32"`
+}
+
+// disableTOTPHandler godoc
+//
+// @Summary Disable two-factor authentication
+// @Description Remove the TOTP secret and recovery codes. Requires the password, or an OIDC reauthentication token, and a current code.
+// @Tags users
+// @Accept json
+// @Param userID path int true "User ID"
+// @Param payload body ReauthenticatePayload true "Password and code"
+// @Success 204 {string} string "Two-factor authentication disabled"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid credentials"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/2fa/totp [delete]
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload ReauthenticatePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.reauthenticate(ctx, user, payload); err != nil {
+		switch err {
+		case errInvalidCredentials:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// regenerateRecoveryCodesHandler godoc
+//
+// @Summary Regenerate recovery codes
+// @Description Replace every recovery code. Requires the password, or an OIDC reauthentication token, and a current code.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param payload body ReauthenticatePayload true "Password and code"
+// @Success 200 {object} RecoveryCodesResponse "Recovery codes"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid credentials"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/2fa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload ReauthenticatePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.reauthenticate(ctx, user, payload); err != nil {
+		switch err {
+		case errInvalidCredentials:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// generateRecoveryCodes returns plain recovery codes formatted as
+// "xxxxx-xxxxx" along with the hashes that get stored.
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the formatting users may or may not type.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+type BlockUserPayload struct {
+	UserID int64 `json:"user_id" validate:"required"`
+}
+
+// blockUserHandler godoc
+//
+// @Summary Block a user
+// @Description Keep another user from partnering with the user. Any partnership and pending partner request between them ends right away. The blocked user isn't told.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param userID path int true "User ID"
+// @Param payload body BlockUserPayload true "User to block"
+// @Success 201 {object} store.UserBlock "User blocked"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 404 {object} ErrorResponse "User to block not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/blocks [post]
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	var payload BlockUserPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.UserID == user.ID {
+		app.badRequestResponse(w, r, errors.New("users cannot block themselves"))
+		return
+	}
+
+	ctx := r.Context()
+
+	blocked, err := app.store.Users.GetByID(ctx, payload.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	block := &store.UserBlock{
+		BlockerID:       user.ID,
+		BlockedID:       blocked.ID,
+		BlockedUsername: blocked.Username,
+	}
+
+	if err := app.store.UserBlocks.Block(ctx, block); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, block); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// getUserBlocksHandler godoc
+//
+// @Summary List blocked users
+// @Description List the users the user blocked
+// @Tags users
+// @Produce json
+// @Param userID path int true "User ID"
+// @Success 200 {array} store.UserBlock "Blocked users"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/blocks [get]
+func (app *application) getUserBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	blocks, err := app.store.UserBlocks.GetByBlockerID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, blocks); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// unblockUserHandler godoc
+//
+// @Summary Unblock a user
+// @Description Let a blocked user partner with the user again. A partnership the block ended isn't restored.
+// @Tags users
+// @Param userID path int true "User ID"
+// @Param blockedID path int true "Blocked user ID"
+// @Success 204 {string} string "User unblocked"
+// @Failure 404 {object} ErrorResponse "Block not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/{userID}/blocks/{blockedID} [delete]
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	blockedID, err := strconv.ParseInt(chi.URLParam(r, "blockedID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.UserBlocks.Unblock(r.Context(), user.ID, blockedID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
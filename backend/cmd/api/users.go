@@ -2,8 +2,8 @@ package main
 
 import (
 	"net/http"
-
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/ssanjose/PingU/internal/store"
@@ -24,8 +24,10 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type UpdateUserPayload struct {
-	Username *string `json:"username" validate:"omitempty,max=35"`
-	Email    *string `json:"email" validate:"omitempty,email"`
+	Username *string `json:"username" validate:"omitempty,max=35,username,notreserved"`
+	Email    *string `json:"email" validate:"omitempty,email,max=255"`
+	// MagicLinkEnabled opts the user in or out of signing in by email link
+	MagicLinkEnabled *bool `json:"magic_link_enabled"`
 }
 
 func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -37,24 +39,58 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if payload.Username != nil {
+		*payload.Username = normalizeUsername(*payload.Username)
+	}
+
 	if err := Validate.Struct(payload); err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
+	ctx := r.Context()
+
+	// the email only switches once the new address confirms it. A taken
+	// email is caught before anything is saved, the change is only staged
+	// once the rest of the update went through.
+	changeEmail := payload.Email != nil && !strings.EqualFold(*payload.Email, user.Email)
+	if changeEmail {
+		if err := app.checkEmailAvailable(ctx, *payload.Email); err != nil {
+			switch err {
+			case store.ErrDuplicateEmail:
+				app.conflictResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	}
+
 	if payload.Username != nil {
 		user.Username = *payload.Username
 	}
 
-	if payload.Email != nil {
-		user.Email = *payload.Email
+	if payload.MagicLinkEnabled != nil {
+		user.MagicLinkEnabled = *payload.MagicLinkEnabled
 	}
 
-	if err := app.store.Users.Update(r.Context(), user); err != nil {
-		app.internalServerError(w, r, err)
+	if err := app.store.Users.Update(ctx, user); err != nil {
+		switch err {
+		case store.ErrDuplicateUsername:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
+	if changeEmail {
+		if err := app.requestEmailChange(ctx, user, *payload.Email); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -86,27 +122,41 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (app *application) pingUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
-	user := getUserFromCtx(r)
-
-	if err := app.store.Users.Ping(r.Context(), user); err != nil {
+// activateUserHandler godoc
+//
+// @Summary Activate a user
+// @Description Activate a user with the invitation token sent to their email. A user whose email was invited by a partner is paired with them.
+// @Tags users
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Success 204 {string} string "User activated"
+// @Failure 404 {object} ErrorResponse "Unknown token"
+// @Failure 410 {object} ErrorResponse "Expired token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/users/activate/{token} [put]
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	err := app.store.Users.Activate(r.Context(), hashToken(token))
+	if err != nil {
 		switch err {
-		case store.ErrPartnerNotFound:
-			app.badRequestResponse(w, r, err)
-			return
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrTokenExpired:
+			app.goneResponse(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
 		}
 		return
 	}
 
-	app.jsonResponse(w, http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (app *application) pongUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) pingUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromCtx(r)
 
-	if err := app.store.Users.Pong(r.Context(), user); err != nil {
+	if err := app.store.Users.Ping(r.Context(), user); err != nil {
 		switch err {
 		case store.ErrPartnerNotFound:
 			app.badRequestResponse(w, r, err)
@@ -120,20 +170,13 @@ func (app *application) pongUserPartnerHandler(w http.ResponseWriter, r *http.Re
 	app.jsonResponse(w, http.StatusNoContent, nil)
 }
 
-func (app *application) setUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) pongUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromCtx(r)
 
-	partnerID, err := strconv.ParseInt(chi.URLParam(r, "partnerID"), 10, 64)
-	if err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
-
-	partner, err := app.store.Users.GetByID(r.Context(), partnerID)
-	if err != nil {
+	if err := app.store.Users.Pong(r.Context(), user); err != nil {
 		switch err {
-		case store.ErrNotFound:
-			app.notFoundResponse(w, r, err)
+		case store.ErrPartnerNotFound:
+			app.badRequestResponse(w, r, err)
 			return
 		default:
 			app.internalServerError(w, r, err)
@@ -141,18 +184,20 @@ func (app *application) setUserPartnerHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if err := app.store.Users.Partner(r.Context(), user, partner); err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
-
 	app.jsonResponse(w, http.StatusNoContent, nil)
 }
 
 func (app *application) unsetUserPartnerHandler(w http.ResponseWriter, r *http.Request) {
+	authUser := getAuthUserFromCtx(r)
 	user := getUserFromCtx(r)
 
-	if err := app.store.Users.Unpartner(r.Context(), user); err != nil {
+	// anyone but the pair ending it is a moderator, see checkPermission
+	reason := store.PartnershipUnpartnered
+	if authUser.ID != user.ID && authUser.ID != user.PartnerID.Int64 {
+		reason = store.PartnershipModerated
+	}
+
+	if err := app.store.Users.Unpartner(r.Context(), user, authUser.ID, reason); err != nil {
 		switch err {
 		case store.ErrPartnerNotFound:
 			app.badRequestResponse(w, r, err)
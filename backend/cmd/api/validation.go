@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nbutton23/zxcvbn-go"
+	"github.com/ssanjose/PingU/internal/store"
+	"golang.org/x/text/unicode/norm"
+)
+
+// validationConfig configures the validationRules of the application.
+type validationConfig struct {
+	reservedUsernames     []string
+	minPasswordScore      int
+	breachedPasswordsFile string
+}
+
+// validationRules back the custom validator tags that depend on the
+// configuration. They are registered on the global Validate once loaded.
+type validationRules struct {
+	reservedUsernames map[string]struct{}
+	minPasswordScore  int
+	breachedPasswords *breachedPasswordList
+}
+
+// zxcvbn gets slow on long input, and passwords this long are strong anyway.
+const maxEstimatedPasswordLength = 64
+
+// usernameScripts are the scripts a username may be written in. Letters of
+// different scripts can't be mixed, which rules out most look-alikes.
+var usernameScripts = [][]*unicode.RangeTable{
+	{unicode.Latin},
+	{unicode.Greek},
+	{unicode.Cyrillic},
+	{unicode.Arabic},
+	{unicode.Hebrew},
+	{unicode.Devanagari},
+	{unicode.Thai},
+	{unicode.Hangul},
+	{unicode.Han, unicode.Hiragana, unicode.Katakana},
+}
+
+func registerValidations(v *validator.Validate) {
+	// report fields by their JSON name
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	must(v.RegisterValidation("username", validateUsername))
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newValidationRules loads the reserved usernames, the password strength
+// threshold and the breached password list.
+func newValidationRules(cfg validationConfig) (*validationRules, error) {
+	rules := &validationRules{
+		reservedUsernames: make(map[string]struct{}, len(cfg.reservedUsernames)),
+		minPasswordScore:  cfg.minPasswordScore,
+	}
+
+	for _, name := range cfg.reservedUsernames {
+		if name = strings.TrimSpace(name); name != "" {
+			rules.reservedUsernames[store.UsernameSkeleton(name)] = struct{}{}
+		}
+	}
+
+	if cfg.breachedPasswordsFile != "" {
+		breached, err := openBreachedPasswords(cfg.breachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		rules.breachedPasswords = breached
+	}
+
+	return rules, nil
+}
+
+// register adds the tags that check the rules to v.
+func (rules *validationRules) register(v *validator.Validate) error {
+	if err := v.RegisterValidation("notreserved", rules.validateNotReserved); err != nil {
+		return err
+	}
+
+	if err := v.RegisterValidation("password", rules.validatePasswordStrength); err != nil {
+		return err
+	}
+
+	return v.RegisterValidation("notbreached", rules.validateNotBreached)
+}
+
+// breachedPasswordList is a file of hex SHA-1 password hashes, one per line
+// and sorted, like the Have I Been Pwned dumps ordered by hash. Lines may
+// carry a ":count" suffix. The file is too large to load, so it is binary
+// searched on every lookup.
+type breachedPasswordList struct {
+	f    *os.File
+	size int64
+}
+
+// breachedPasswordsScanSize is how far apart two lines may be before the
+// search reads through the lines in between instead.
+const breachedPasswordsScanSize = 4096
+
+func openBreachedPasswords(path string) (*breachedPasswordList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	list := &breachedPasswordList{f: f, size: info.Size()}
+
+	// catch a file in the wrong format now rather than on the first lookup
+	if list.size > 0 {
+		if _, _, err := list.readLine(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// contains reports whether the hash of a password is on the list.
+func (l *breachedPasswordList) contains(sum [sha1.Size]byte) (bool, error) {
+	// lo is always the start of a line, hi the start of a line or the end
+	lo, hi := int64(0), l.size
+	for hi-lo > breachedPasswordsScanSize {
+		mid, err := l.nextLineStart((lo + hi) / 2)
+		if err != nil {
+			return false, err
+		}
+
+		if mid >= hi {
+			break
+		}
+
+		hash, _, err := l.readLine(mid)
+		if err != nil {
+			return false, err
+		}
+
+		if bytes.Compare(hash[:], sum[:]) < 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	// the hash is at hi, if not in between
+	for offset := lo; offset < l.size; {
+		hash, next, err := l.readLine(offset)
+		if err != nil {
+			return false, err
+		}
+
+		switch c := bytes.Compare(hash[:], sum[:]); {
+		case c == 0:
+			return true, nil
+		case c > 0:
+			return false, nil
+		}
+
+		offset = next
+	}
+
+	return false, nil
+}
+
+// nextLineStart returns where the first line after offset starts, or the end
+// of the file.
+func (l *breachedPasswordList) nextLineStart(offset int64) (int64, error) {
+	buf := make([]byte, 128)
+	for offset < l.size {
+		n, err := l.f.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		offset += int64(n)
+	}
+
+	return l.size, nil
+}
+
+// readLine parses the line starting at offset and returns where the next one
+// starts.
+func (l *breachedPasswordList) readLine(offset int64) ([sha1.Size]byte, int64, error) {
+	var sum [sha1.Size]byte
+
+	// a hash with its count fits in far less
+	buf := make([]byte, 128)
+	n, err := l.f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return sum, 0, err
+	}
+
+	line := buf[:n]
+	next := offset + int64(n)
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+		next = offset + int64(i) + 1
+	}
+
+	hash, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+	if n, err := hex.Decode(sum[:], hash); err != nil || n != sha1.Size {
+		return sum, 0, fmt.Errorf("%s at byte %d: not a SHA-1 hash", l.f.Name(), offset)
+	}
+
+	return sum, next, nil
+}
+
+// normalizeUsername folds compatibility characters, like full-width letters,
+// into their canonical form before the username is validated and stored.
+func normalizeUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
+
+// validateUsername accepts letters of a single script, ASCII digits and
+// inner '.', '_' or '-'.
+func validateUsername(fl validator.FieldLevel) bool {
+	username := fl.Field().String()
+	if username == "" || !norm.NFKC.IsNormalString(username) {
+		return false
+	}
+
+	first, _ := utf8.DecodeRuneInString(username)
+	last, _ := utf8.DecodeLastRuneInString(username)
+	if isUsernameSeparator(first) || isUsernameSeparator(last) {
+		return false
+	}
+
+	var script []*unicode.RangeTable
+	for _, r := range username {
+		switch {
+		case r >= '0' && r <= '9', isUsernameSeparator(r):
+			continue
+		case !unicode.IsLetter(r):
+			return false
+		}
+
+		if script == nil {
+			script = usernameScriptOf(r)
+			if script == nil {
+				return false
+			}
+		}
+
+		if !unicode.In(r, script...) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isUsernameSeparator(r rune) bool {
+	return r == '.' || r == '_' || r == '-'
+}
+
+func usernameScriptOf(r rune) []*unicode.RangeTable {
+	for _, script := range usernameScripts {
+		if unicode.In(r, script...) {
+			return script
+		}
+	}
+	return nil
+}
+
+func (rules *validationRules) validateNotReserved(fl validator.FieldLevel) bool {
+	_, reserved := rules.reservedUsernames[store.UsernameSkeleton(fl.Field().String())]
+	return !reserved
+}
+
+func (rules *validationRules) validatePasswordStrength(fl validator.FieldLevel) bool {
+	password := fl.Field().String()
+	if utf8.RuneCountInString(password) >= maxEstimatedPasswordLength {
+		return true
+	}
+
+	return zxcvbn.PasswordStrength(password, nil).Score >= rules.minPasswordScore
+}
+
+func (rules *validationRules) validateNotBreached(fl validator.FieldLevel) bool {
+	if rules.breachedPasswords == nil {
+		return true
+	}
+
+	breached, err := rules.breachedPasswords.contains(sha1.Sum([]byte(fl.Field().String())))
+	if err != nil {
+		// a broken list shouldn't keep everyone from picking a password
+		log.Printf("error checking breached passwords: %s", err.Error())
+		return true
+	}
+
+	return !breached
+}
+
+// validationMessages explains a failed validation for each field, by its
+// JSON name.
+func validationMessages(errs validator.ValidationErrors) map[string]string {
+	messages := make(map[string]string, len(errs))
+
+	for _, err := range errs {
+		var msg string
+
+		switch err.Tag() {
+		case "required":
+			msg = "is required"
+		case "email":
+			msg = "must be a valid email address"
+		case "min":
+			msg = fmt.Sprintf("must be at least %s characters long", err.Param())
+		case "max":
+			msg = fmt.Sprintf("must be at most %s characters long", err.Param())
+		case "username":
+			msg = "may only contain letters of a single script, digits, and '.', '_' or '-' between them"
+		case "notreserved":
+			msg = "is reserved"
+		case "password":
+			msg = "is too easy to guess"
+		case "notbreached":
+			msg = "has appeared in a data breach, choose another one"
+		default:
+			msg = fmt.Sprintf("failed the %q check", err.Tag())
+		}
+
+		messages[err.Field()] = msg
+	}
+
+	return messages
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const webAuthnCeremonyExp = time.Minute * 5
+
+// webAuthnUser adapts a user and their passkeys to webauthn.User.
+type webAuthnUser struct {
+	user        *store.User
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatInt(u.user.ID, 10))
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (app *application) loadWebAuthnUser(ctx context.Context, user *store.User) (*webAuthnUser, error) {
+	stored, err := app.store.WebAuthn.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, len(stored))
+	for i, c := range stored {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+type WebAuthnCeremonyResponse struct {
+	CeremonyID string `json:"ceremony_id"`
+	Options    any    `json:"options"`
+}
+
+// createWebAuthnCeremony keeps the session data of a ceremony until the
+// client finishes it.
+func (app *application) createWebAuthnCeremony(ctx context.Context, userID int64, session *webauthn.SessionData, options any) (*WebAuthnCeremonyResponse, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := app.store.WebAuthn.CreateCeremony(ctx, userID, data, webAuthnCeremonyExp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnCeremonyResponse{CeremonyID: id, Options: options}, nil
+}
+
+// consumeWebAuthnCeremony returns the session data of an unfinished ceremony.
+func (app *application) consumeWebAuthnCeremony(ctx context.Context, id string, userID int64) (*webauthn.SessionData, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, store.ErrNotFound
+	}
+
+	data, err := app.store.WebAuthn.ConsumeCeremony(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// beginWebAuthnRegistrationHandler godoc
+//
+// @Summary Begin a passkey registration
+// @Description Create the options the authenticator needs to register a new passkey
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} WebAuthnCeremonyResponse "Ceremony ID and creation options"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/webauthn/register/begin [post]
+func (app *application) beginWebAuthnRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+	ctx := r.Context()
+
+	wu, err := app.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(wu.credentials))
+	for i, c := range wu.credentials {
+		exclusions[i] = c.Descriptor()
+	}
+
+	creation, session, err := app.webAuthn.BeginRegistration(
+		wu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ceremony, err := app.createWebAuthnCeremony(ctx, user.ID, session, creation)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, ceremony); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type FinishWebAuthnRegistrationPayload struct {
+	CeremonyID string          `json:"ceremony_id" validate:"required"`
+	Name       string          `json:"name" validate:"required,max=100"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// finishWebAuthnRegistrationHandler godoc
+//
+// @Summary Finish a passkey registration
+// @Description Verify the authenticator's attestation and store the passkey under a user-chosen name
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body FinishWebAuthnRegistrationPayload true "Ceremony ID, name and credential"
+// @Success 201 {object} store.WebAuthnCredential "Passkey registered"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 404 {object} ErrorResponse "Unknown or expired ceremony"
+// @Failure 409 {object} ErrorResponse "Passkey already registered"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/webauthn/register/finish [post]
+func (app *application) finishWebAuthnRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	var payload FinishWebAuthnRegistrationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	session, err := app.consumeWebAuthnCeremony(ctx, payload.CeremonyID, user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(payload.Credential)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	wu, err := app.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	credential, err := app.webAuthn.CreateCredential(wu, *session, parsed)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	stored := &store.WebAuthnCredential{
+		UserID:          user.ID,
+		Name:            payload.Name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+
+	if err := app.store.WebAuthn.Create(ctx, stored); err != nil {
+		switch err {
+		case store.ErrDuplicateCredential:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, stored); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// beginWebAuthnLoginHandler godoc
+//
+// @Summary Begin a passkey login
+// @Description Create the options the authenticator needs to sign in with a discoverable passkey
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} WebAuthnCeremonyResponse "Ceremony ID and assertion options"
+// @Failure 429 {object} ErrorResponse "Too many logins"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/webauthn/login/begin [post]
+func (app *application) beginWebAuthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// anyone can begin a login, and every one is stored, so they are limited
+	// per IP
+	if app.webAuthnLoginLimiter.RespondOnLimit(w, r, clientIP(r)) {
+		return
+	}
+
+	assertion, session, err := app.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ceremony, err := app.createWebAuthnCeremony(r.Context(), 0, session, assertion)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, ceremony); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type FinishWebAuthnLoginPayload struct {
+	CeremonyID string          `json:"ceremony_id" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// finishWebAuthnLoginHandler godoc
+//
+// @Summary Finish a passkey login
+// @Description Verify the authenticator's assertion and issue access and refresh tokens
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param payload body FinishWebAuthnLoginPayload true "Ceremony ID and credential"
+// @Success 200 {object} TokenResponse "Access and refresh tokens"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Invalid assertion"
+// @Failure 403 {object} ErrorResponse "Account not activated"
+// @Failure 429 {object} ErrorResponse "Login locked out"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/authentication/webauthn/login/finish [post]
+func (app *application) finishWebAuthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload FinishWebAuthnLoginPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	session, err := app.consumeWebAuthnCeremony(ctx, payload.CeremonyID, 0)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(payload.Credential)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// the user handle the authenticator returns is the user's ID
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.ParseInt(string(userHandle), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := app.store.Users.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		return app.loadWebAuthnUser(ctx, user)
+	}
+
+	found, credential, err := app.webAuthn.ValidatePasskeyLogin(findUser, *session, parsed)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.WebAuthn.RecordLogin(ctx, credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user := found.(*webAuthnUser).user
+	if !user.Verified {
+		app.forbiddenResponse(w, r, errors.New("user has not activated their account"))
+		return
+	}
+
+	token, err := app.createSession(r, user)
+	if err != nil {
+		app.createSessionErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordLoginEvent(w, r, user, store.LoginMethodWebAuthn, store.LoginOutcomeSuccess)
+
+	if err := app.jsonResponse(w, http.StatusOK, token); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) getWebAuthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	credentials, err := app.store.WebAuthn.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, credentials); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type RenameWebAuthnCredentialPayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+func (app *application) renameWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "credentialID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload RenameWebAuthnCredentialPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.WebAuthn.Rename(r.Context(), user.ID, id, payload.Name); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrDuplicateCredential:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) deleteWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "credentialID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.WebAuthn.Delete(r.Context(), user.ID, id); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
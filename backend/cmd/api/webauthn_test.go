@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/ssanjose/PingU/internal/store"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+var errNotImplemented = errors.New("not implemented")
+
+// softAuthenticator is a passkey authenticator in software. It holds one
+// discoverable P-256 credential and counts its signatures.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+// authenticatorData builds the authenticator data, with the attested
+// credential when attested is set.
+func (a *softAuthenticator) authenticatorData(t *testing.T, attested bool) []byte {
+	t.Helper()
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	// user present and user verified
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+
+	var data bytes.Buffer
+	data.Write(rpIDHash[:])
+	data.WriteByte(flags)
+	binary.Write(&data, binary.BigEndian, a.signCount)
+
+	if attested {
+		publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+			PublicKeyData: webauthncose.PublicKeyData{
+				KeyType:   int64(webauthncose.EllipticKey),
+				Algorithm: int64(webauthncose.AlgES256),
+			},
+			Curve:  int64(webauthncose.P256),
+			XCoord: a.key.X.FillBytes(make([]byte, 32)),
+			YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data.Write(make([]byte, 16)) // AAGUID
+		binary.Write(&data, binary.BigEndian, uint16(len(a.credentialID)))
+		data.Write(a.credentialID)
+		data.Write(publicKey)
+	}
+
+	return data.Bytes()
+}
+
+func clientData(t *testing.T, ceremonyType, challenge string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// create answers the creation options of a registration ceremony.
+func (a *softAuthenticator) create(t *testing.T, options json.RawMessage) json.RawMessage {
+	t.Helper()
+
+	var creation struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &creation); err != nil {
+		t.Fatal(err)
+	}
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(creation.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mustMarshal(t, map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData(t, "webauthn.create", creation.PublicKey.Challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// get answers the assertion options of a login ceremony, signing with the
+// next counter value.
+func (a *softAuthenticator) get(t *testing.T, options json.RawMessage) json.RawMessage {
+	t.Helper()
+
+	var assertion struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		t.Fatal(err)
+	}
+
+	a.signCount++
+
+	authData := a.authenticatorData(t, false)
+	client := clientData(t, "webauthn.get", assertion.PublicKey.Challenge)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mustMarshal(t, map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(client),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	})
+}
+
+func mustMarshal(t *testing.T, v any) json.RawMessage {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// fakeWebAuthnStore keeps passkeys and ceremonies in memory.
+type fakeWebAuthnStore struct {
+	mu          sync.Mutex
+	credentials []store.WebAuthnCredential
+	ceremonies  map[string]fakeCeremony
+}
+
+type fakeCeremony struct {
+	userID  int64
+	session []byte
+	expiry  time.Time
+}
+
+func (s *fakeWebAuthnStore) Create(ctx context.Context, credential *store.WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.credentials {
+		if bytes.Equal(c.CredentialID, credential.CredentialID) {
+			return store.ErrDuplicateCredential
+		}
+	}
+
+	credential.ID = int64(len(s.credentials) + 1)
+	credential.CreatedAt = time.Now()
+	s.credentials = append(s.credentials, *credential)
+
+	return nil
+}
+
+func (s *fakeWebAuthnStore) GetByUserID(ctx context.Context, userID int64) ([]store.WebAuthnCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := []store.WebAuthnCredential{}
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			credentials = append(credentials, c)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (s *fakeWebAuthnStore) RecordLogin(ctx context.Context, credentialID []byte, signCount uint32, backupState bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.credentials {
+		if bytes.Equal(c.CredentialID, credentialID) {
+			s.credentials[i].SignCount = signCount
+			s.credentials[i].BackupState = backupState
+			s.credentials[i].LastUsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeWebAuthnStore) Rename(ctx context.Context, userID, id int64, name string) error {
+	return errNotImplemented
+}
+
+func (s *fakeWebAuthnStore) Delete(ctx context.Context, userID, id int64) error {
+	return errNotImplemented
+}
+
+func (s *fakeWebAuthnStore) CreateCeremony(ctx context.Context, userID int64, session []byte, exp time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := uuid.NewString()
+	s.ceremonies[id] = fakeCeremony{userID: userID, session: session, expiry: time.Now().Add(exp)}
+
+	return id, nil
+}
+
+func (s *fakeWebAuthnStore) ConsumeCeremony(ctx context.Context, id string, userID int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ceremony, ok := s.ceremonies[id]
+	if !ok || ceremony.userID != userID || !ceremony.expiry.After(time.Now()) {
+		return nil, store.ErrNotFound
+	}
+	delete(s.ceremonies, id)
+
+	return ceremony.session, nil
+}
+
+func (s *fakeWebAuthnStore) DeleteExpiredCeremonies(ctx context.Context, limit int) (int64, error) {
+	return 0, errNotImplemented
+}
+
+// fakeUserStore only looks users up by ID.
+type fakeUserStore struct {
+	users map[int64]*store.User
+}
+
+func (s *fakeUserStore) Create(context.Context, *sql.Tx, *store.User) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) CreateAndInvite(ctx context.Context, user *store.User, token string, exp time.Duration) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) CreateWithIdentity(context.Context, *store.User, *store.Identity) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) Reinvite(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) Activate(ctx context.Context, token string) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (s *fakeUserStore) GetByEmail(context.Context, string) (*store.User, error) {
+	return nil, errNotImplemented
+}
+
+func (s *fakeUserStore) Update(context.Context, *store.User) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) UpdatePassword(context.Context, *store.User) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) Delete(context.Context, int64) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) DeleteExpiredInvitations(ctx context.Context, limit int) (int64, error) {
+	return 0, errNotImplemented
+}
+
+func (s *fakeUserStore) DeleteUnverified(ctx context.Context, createdBefore time.Time, limit int) (int64, error) {
+	return 0, errNotImplemented
+}
+
+func (s *fakeUserStore) Unpartner(ctx context.Context, user *store.User, endedBy int64, reason string) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) Ping(context.Context, *store.User) error {
+	return errNotImplemented
+}
+
+func (s *fakeUserStore) Pong(context.Context, *store.User) error {
+	return errNotImplemented
+}
+
+// fakeLoginThrottleStore never locks anyone out.
+type fakeLoginThrottleStore struct{}
+
+func (s *fakeLoginThrottleStore) LockedUntil(ctx context.Context, email, ip string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (s *fakeLoginThrottleStore) RecordFailure(ctx context.Context, scope, key string, policy store.LockoutPolicy) (*store.LoginThrottle, error) {
+	return nil, errNotImplemented
+}
+
+func (s *fakeLoginThrottleStore) Clear(ctx context.Context, scope, key string) error {
+	return nil
+}
+
+// fakeSessionStore only counts the sessions it was asked to create.
+type fakeSessionStore struct {
+	mu      sync.Mutex
+	created int
+}
+
+func (s *fakeSessionStore) Create(context.Context, *store.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.created++
+
+	return nil
+}
+
+func (s *fakeSessionStore) Rotate(ctx context.Context, token string, next *store.Session) error {
+	return errNotImplemented
+}
+
+func (s *fakeSessionStore) GetByUserID(context.Context, int64) ([]store.Session, error) {
+	return nil, errNotImplemented
+}
+
+func (s *fakeSessionStore) Revoke(ctx context.Context, userID int64, id string) error {
+	return errNotImplemented
+}
+
+func (s *fakeSessionStore) RevokeByToken(ctx context.Context, token string) error {
+	return errNotImplemented
+}
+
+func (s *fakeSessionStore) RevokeAll(ctx context.Context, userID int64) error {
+	return errNotImplemented
+}
+
+// fakeSecurityEventStore never sees a new device, so no alert is mailed.
+type fakeSecurityEventStore struct{}
+
+func (s *fakeSecurityEventStore) Record(ctx context.Context, event *store.SecurityEvent, dedupe time.Duration) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeSecurityEventStore) GetByUserID(ctx context.Context, userID, before int64, limit int) ([]store.SecurityEvent, error) {
+	return nil, errNotImplemented
+}
+
+type fakeAuthenticator struct{}
+
+func (a *fakeAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
+	return "token", nil
+}
+
+func (a *fakeAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	return nil, errNotImplemented
+}
+
+type webAuthnTest struct {
+	app      *application
+	user     *store.User
+	passkeys *fakeWebAuthnStore
+	sessions *fakeSessionStore
+}
+
+func newWebAuthnTest(t *testing.T) *webAuthnTest {
+	t.Helper()
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "PingU",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &store.User{ID: 42, Username: "pingu", Email: "pingu@example.com", Verified: true}
+	passkeys := &fakeWebAuthnStore{ceremonies: map[string]fakeCeremony{}}
+	sessions := &fakeSessionStore{}
+
+	app := &application{
+		config: config{
+			auth: authConfig{
+				token:      tokenConfig{exp: time.Hour, iss: "pingu"},
+				refreshExp: time.Hour,
+			},
+		},
+		store: store.Storage{
+			Users:          &fakeUserStore{users: map[int64]*store.User{user.ID: user}},
+			WebAuthn:       passkeys,
+			LoginThrottles: &fakeLoginThrottleStore{},
+			Sessions:       sessions,
+			SecurityEvents: &fakeSecurityEventStore{},
+		},
+		authenticator:        &fakeAuthenticator{},
+		webAuthnLoginLimiter: httprate.NewRateLimiter(3, time.Minute),
+		webAuthn:             wa,
+	}
+
+	return &webAuthnTest{app: app, user: user, passkeys: passkeys, sessions: sessions}
+}
+
+// do calls the handler, as the test user when authenticated is set, and
+// decodes a successful response into res.
+func (wt *webAuthnTest) do(t *testing.T, handler http.HandlerFunc, authenticated bool, payload, res any) int {
+	t.Helper()
+
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if authenticated {
+		r = r.WithContext(context.WithValue(r.Context(), authUserCtx, wt.user))
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if res != nil && w.Code < http.StatusBadRequest {
+		envelope := struct {
+			Data any `json:"data"`
+		}{Data: res}
+		if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code
+}
+
+type testCeremony struct {
+	CeremonyID string          `json:"ceremony_id"`
+	Options    json.RawMessage `json:"options"`
+}
+
+func (wt *webAuthnTest) register(t *testing.T, authenticator *softAuthenticator) {
+	t.Helper()
+
+	var begin testCeremony
+	if code := wt.do(t, wt.app.beginWebAuthnRegistrationHandler, true, nil, &begin); code != http.StatusOK {
+		t.Fatalf("begin registration: got status %d", code)
+	}
+
+	payload := FinishWebAuthnRegistrationPayload{
+		CeremonyID: begin.CeremonyID,
+		Name:       "laptop",
+		Credential: authenticator.create(t, begin.Options),
+	}
+
+	if code := wt.do(t, wt.app.finishWebAuthnRegistrationHandler, true, payload, nil); code != http.StatusCreated {
+		t.Fatalf("finish registration: got status %d", code)
+	}
+}
+
+func (wt *webAuthnTest) login(t *testing.T, authenticator *softAuthenticator) (int, *TokenResponse) {
+	t.Helper()
+
+	var begin testCeremony
+	if code := wt.do(t, wt.app.beginWebAuthnLoginHandler, false, nil, &begin); code != http.StatusOK {
+		t.Fatalf("begin login: got status %d", code)
+	}
+
+	payload := FinishWebAuthnLoginPayload{
+		CeremonyID: begin.CeremonyID,
+		Credential: authenticator.get(t, begin.Options),
+	}
+
+	var token TokenResponse
+	code := wt.do(t, wt.app.finishWebAuthnLoginHandler, false, payload, &token)
+
+	return code, &token
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+
+	wt.register(t, authenticator)
+
+	stored, _ := wt.passkeys.GetByUserID(context.Background(), wt.user.ID)
+	if len(stored) != 1 || !bytes.Equal(stored[0].CredentialID, authenticator.credentialID) {
+		t.Fatalf("expected the passkey to be stored, got %+v", stored)
+	}
+
+	code, token := wt.login(t, authenticator)
+	if code != http.StatusOK {
+		t.Fatalf("finish login: got status %d", code)
+	}
+
+	if token.Token == "" || token.RefreshToken == "" {
+		t.Fatalf("expected access and refresh tokens, got %+v", token)
+	}
+
+	if wt.sessions.created != 1 {
+		t.Fatalf("expected one session, got %d", wt.sessions.created)
+	}
+
+	stored, _ = wt.passkeys.GetByUserID(context.Background(), wt.user.ID)
+	if stored[0].SignCount != authenticator.signCount {
+		t.Fatalf("expected sign count %d, got %d", authenticator.signCount, stored[0].SignCount)
+	}
+}
+
+func TestWebAuthnRegisterTwice(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+
+	wt.register(t, authenticator)
+
+	// the registered passkey is excluded, an authenticator that registers it
+	// anyway is turned away
+	var begin testCeremony
+	wt.do(t, wt.app.beginWebAuthnRegistrationHandler, true, nil, &begin)
+
+	payload := FinishWebAuthnRegistrationPayload{
+		CeremonyID: begin.CeremonyID,
+		Name:       "phone",
+		Credential: authenticator.create(t, begin.Options),
+	}
+
+	if code := wt.do(t, wt.app.finishWebAuthnRegistrationHandler, true, payload, nil); code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, code)
+	}
+}
+
+func TestWebAuthnCeremonyIsSingleUse(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+
+	wt.register(t, authenticator)
+
+	var begin testCeremony
+	wt.do(t, wt.app.beginWebAuthnLoginHandler, false, nil, &begin)
+
+	payload := FinishWebAuthnLoginPayload{
+		CeremonyID: begin.CeremonyID,
+		Credential: authenticator.get(t, begin.Options),
+	}
+
+	if code := wt.do(t, wt.app.finishWebAuthnLoginHandler, false, payload, nil); code != http.StatusOK {
+		t.Fatalf("finish login: got status %d", code)
+	}
+
+	if code := wt.do(t, wt.app.finishWebAuthnLoginHandler, false, payload, nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected a replayed login to get status %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestWebAuthnLoginWithUnknownPasskey(t *testing.T) {
+	wt := newWebAuthnTest(t)
+
+	wt.register(t, newSoftAuthenticator(t))
+
+	// a different key under the same user handle
+	stranger := newSoftAuthenticator(t)
+	stranger.userHandle = []byte(strconv.FormatInt(wt.user.ID, 10))
+
+	if code, _ := wt.login(t, stranger); code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	if wt.sessions.created != 0 {
+		t.Fatalf("expected no session, got %d", wt.sessions.created)
+	}
+}
+
+func TestWebAuthnLoginWithForgedSignature(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+
+	wt.register(t, authenticator)
+
+	// the stored public key no longer matches the signing key
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.key = key
+
+	if code, _ := wt.login(t, authenticator); code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestWebAuthnLoginBeginIsRateLimited(t *testing.T) {
+	wt := newWebAuthnTest(t)
+
+	for i := 0; i < 3; i++ {
+		if code := wt.do(t, wt.app.beginWebAuthnLoginHandler, false, nil, nil); code != http.StatusOK {
+			t.Fatalf("begin login %d: got status %d", i+1, code)
+		}
+	}
+
+	if code := wt.do(t, wt.app.beginWebAuthnLoginHandler, false, nil, nil); code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	if len(wt.passkeys.ceremonies) != 3 {
+		t.Fatalf("expected 3 ceremonies, got %d", len(wt.passkeys.ceremonies))
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+  token bytea PRIMARY KEY,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  user_id BIGINT NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id);
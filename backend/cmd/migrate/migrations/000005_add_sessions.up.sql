@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions (
+  id BIGSERIAL PRIMARY KEY,
+  family_id UUID NOT NULL,
+  user_id BIGINT NOT NULL,
+  token bytea UNIQUE NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  ip TEXT NOT NULL DEFAULT '',
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  started_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  rotated_at TIMESTAMP(0) WITH TIME ZONE,
+  revoked_at TIMESTAMP(0) WITH TIME ZONE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_family_id ON sessions(family_id);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS roles (
+  id BIGSERIAL PRIMARY KEY,
+  name VARCHAR(255) UNIQUE NOT NULL,
+  level INT NOT NULL DEFAULT 0,
+  description TEXT
+);
+
+INSERT INTO roles (name, level, description)
+VALUES
+  ('user', 1, 'A user can ping and partner on their own account'),
+  ('moderator', 2, 'A moderator can fix partnerships of other users'),
+  ('admin', 3, 'An admin can manage every user account')
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE IF EXISTS users
+ADD COLUMN IF NOT EXISTS role_id BIGINT REFERENCES roles(id);
+
+UPDATE users
+SET role_id = (SELECT id FROM roles WHERE name = 'user')
+WHERE role_id IS NULL;
+
+ALTER TABLE users
+ALTER COLUMN role_id SET NOT NULL;
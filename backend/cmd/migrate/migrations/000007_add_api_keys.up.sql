@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  token bytea UNIQUE NOT NULL,
+  scopes TEXT [] NOT NULL DEFAULT '{}',
+  expiry TIMESTAMP(0) WITH TIME ZONE,
+  last_used_at TIMESTAMP(0) WITH TIME ZONE,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT api_keys_user_id_name_key UNIQUE (user_id, name)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+  scope VARCHAR(16) NOT NULL,
+  key CITEXT NOT NULL,
+  failures INT NOT NULL DEFAULT 0,
+  last_failed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  locked_until TIMESTAMP(0) WITH TIME ZONE,
+  PRIMARY KEY (scope, key)
+);
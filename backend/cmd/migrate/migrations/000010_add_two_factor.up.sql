@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+  user_id BIGINT PRIMARY KEY,
+  secret TEXT NOT NULL,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  confirmed_at TIMESTAMP(0) WITH TIME ZONE,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  code bytea NOT NULL,
+  used_at TIMESTAMP(0) WITH TIME ZONE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT user_recovery_codes_user_id_code_key UNIQUE (user_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  token bytea PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
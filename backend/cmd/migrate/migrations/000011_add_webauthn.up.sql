@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  credential_id bytea UNIQUE NOT NULL,
+  public_key bytea NOT NULL,
+  attestation_type TEXT NOT NULL DEFAULT '',
+  aaguid bytea,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  transports TEXT [] NOT NULL DEFAULT '{}',
+  backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+  backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+  last_used_at TIMESTAMP(0) WITH TIME ZONE,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT webauthn_credentials_user_id_name_key UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id BIGINT,
+  session JSONB NOT NULL,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
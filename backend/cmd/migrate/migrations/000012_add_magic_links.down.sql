@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS magic_links;
+
+ALTER TABLE users DROP COLUMN IF EXISTS magic_link_enabled;
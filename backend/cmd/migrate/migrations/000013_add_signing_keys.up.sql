@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+  kid VARCHAR(64) PRIMARY KEY,
+  algorithm VARCHAR(16) NOT NULL,
+  private_key bytea NOT NULL,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  retires_at TIMESTAMP(0) WITH TIME ZONE,
+  expires_at TIMESTAMP(0) WITH TIME ZONE
+);
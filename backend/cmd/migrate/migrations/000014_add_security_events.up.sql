@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS security_events (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  method VARCHAR(16) NOT NULL,
+  outcome VARCHAR(16) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  browser VARCHAR(64) NOT NULL DEFAULT '',
+  os VARCHAR(64) NOT NULL DEFAULT '',
+  device VARCHAR(16) NOT NULL DEFAULT '',
+  fingerprint bytea NOT NULL,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_fingerprint ON security_events (user_id, fingerprint);
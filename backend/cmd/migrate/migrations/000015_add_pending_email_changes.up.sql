@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS pending_email_changes (
+  token bytea PRIMARY KEY,
+  user_id BIGINT NOT NULL UNIQUE,
+  new_email CITEXT NOT NULL,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_unverified_created_at;
+DROP INDEX IF EXISTS idx_user_invitations_expiry;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_user_invitations_expiry ON user_invitations (expiry);
+CREATE INDEX IF NOT EXISTS idx_users_unverified_created_at ON users (created_at) WHERE verified = FALSE;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS partner_requests (
+  id BIGSERIAL PRIMARY KEY,
+  sender_id BIGINT NOT NULL,
+  recipient_id BIGINT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  responded_at TIMESTAMP(0) WITH TIME ZONE,
+  FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (recipient_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT check_partner_request_status CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled', 'expired')),
+  CONSTRAINT check_self_partner_request CHECK (sender_id != recipient_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_partner_requests_pending ON partner_requests (sender_id, recipient_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_partner_requests_recipient_id ON partner_requests (recipient_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_partner_requests_expiry ON partner_requests (expiry) WHERE status = 'pending';
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS partner_invites (
+  token bytea PRIMARY KEY,
+  inviter_id BIGINT NOT NULL,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (inviter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_partner_invites_inviter_id ON partner_invites (inviter_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS partner_email_invites (
+  id BIGSERIAL PRIMARY KEY,
+  inviter_id BIGINT NOT NULL,
+  email CITEXT NOT NULL,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (inviter_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT unique_partner_email_invite UNIQUE (inviter_id, email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_partner_email_invites_email ON partner_email_invites (email);
+CREATE INDEX IF NOT EXISTS idx_partner_email_invites_expiry ON partner_email_invites (expiry);
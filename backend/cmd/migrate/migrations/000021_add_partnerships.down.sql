@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pinged_partner_count INT DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS partner_id BIGINT REFERENCES users(id) ON DELETE SET NULL DEFERRABLE INITIALLY IMMEDIATE;
+ALTER TABLE users ADD CONSTRAINT check_self_partner CHECK (id != partner_id);
+CREATE INDEX IF NOT EXISTS idx_users_partner_id ON users(partner_id);
+
+UPDATE users u
+SET partner_id = CASE WHEN p.user_a = u.id THEN p.user_b ELSE p.user_a END,
+  pinged_partner_count = CASE WHEN p.user_a = u.id THEN p.user_a_pings ELSE p.user_b_pings END
+FROM partnerships p
+WHERE p.ended_at IS NULL AND u.id IN (p.user_a, p.user_b);
+
+DROP TABLE IF EXISTS partnerships;
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS partnerships (
+  id BIGSERIAL PRIMARY KEY,
+  user_a BIGINT NOT NULL,
+  user_b BIGINT NOT NULL,
+  started_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  ended_at TIMESTAMP(0) WITH TIME ZONE,
+  ended_by BIGINT,
+  reason VARCHAR(16),
+  user_a_pings BIGINT NOT NULL DEFAULT 0,
+  user_b_pings BIGINT NOT NULL DEFAULT 0,
+  user_a_pongs BIGINT NOT NULL DEFAULT 0,
+  user_b_pongs BIGINT NOT NULL DEFAULT 0,
+  FOREIGN KEY (user_a) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_b) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (ended_by) REFERENCES users(id) ON DELETE SET NULL,
+  CONSTRAINT check_self_partnership CHECK (user_a != user_b),
+  CONSTRAINT check_partnership_reason CHECK (reason IN ('unpartnered', 'moderated', 'blocked'))
+);
+
+-- a user is in at most one current partnership, as either member
+CREATE UNIQUE INDEX IF NOT EXISTS idx_partnerships_current_user_a ON partnerships (user_a) WHERE ended_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_partnerships_current_user_b ON partnerships (user_b) WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_partnerships_user_a ON partnerships (user_a);
+CREATE INDEX IF NOT EXISTS idx_partnerships_user_b ON partnerships (user_b);
+
+-- when a pair was partnered wasn't recorded, it can't have been before both
+-- accounts existed
+INSERT INTO partnerships (user_a, user_b, started_at, user_a_pings, user_b_pings)
+SELECT a.id, b.id, GREATEST(a.created_at, b.created_at), COALESCE(a.pinged_partner_count, 0), COALESCE(b.pinged_partner_count, 0)
+FROM users a
+JOIN users b ON b.id = a.partner_id AND b.partner_id = a.id
+WHERE a.id < b.id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS partner_id;
+ALTER TABLE users DROP COLUMN IF EXISTS pinged_partner_count;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS reauth_tokens;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS reauth_tokens (
+  token bytea PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reauth_tokens_expiry ON reauth_tokens (expiry);
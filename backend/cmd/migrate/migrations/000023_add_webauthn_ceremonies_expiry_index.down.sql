@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_webauthn_ceremonies_expiry;
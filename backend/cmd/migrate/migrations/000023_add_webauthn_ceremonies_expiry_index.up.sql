@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_webauthn_ceremonies_expiry ON webauthn_ceremonies (expiry);
@@ -0,0 +1,13 @@
+DELETE FROM partnerships WHERE user_a IS NULL OR user_b IS NULL;
+
+ALTER TABLE partnerships DROP CONSTRAINT IF EXISTS check_partnership_reason;
+UPDATE partnerships SET reason = 'unpartnered' WHERE reason = 'deleted';
+ALTER TABLE partnerships ADD CONSTRAINT check_partnership_reason CHECK (reason IN ('unpartnered', 'moderated', 'blocked'));
+
+ALTER TABLE partnerships DROP CONSTRAINT IF EXISTS partnerships_user_a_fkey;
+ALTER TABLE partnerships DROP CONSTRAINT IF EXISTS partnerships_user_b_fkey;
+ALTER TABLE partnerships ADD CONSTRAINT partnerships_user_a_fkey FOREIGN KEY (user_a) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE partnerships ADD CONSTRAINT partnerships_user_b_fkey FOREIGN KEY (user_b) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE partnerships ALTER COLUMN user_a SET NOT NULL;
+ALTER TABLE partnerships ALTER COLUMN user_b SET NOT NULL;
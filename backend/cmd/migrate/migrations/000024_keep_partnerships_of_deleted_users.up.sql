@@ -0,0 +1,12 @@
+-- deleting a user ends their partnership instead of erasing it from their
+-- partner's history
+ALTER TABLE partnerships ALTER COLUMN user_a DROP NOT NULL;
+ALTER TABLE partnerships ALTER COLUMN user_b DROP NOT NULL;
+
+ALTER TABLE partnerships DROP CONSTRAINT IF EXISTS partnerships_user_a_fkey;
+ALTER TABLE partnerships DROP CONSTRAINT IF EXISTS partnerships_user_b_fkey;
+ALTER TABLE partnerships ADD CONSTRAINT partnerships_user_a_fkey FOREIGN KEY (user_a) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE partnerships ADD CONSTRAINT partnerships_user_b_fkey FOREIGN KEY (user_b) REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE partnerships DROP CONSTRAINT IF EXISTS check_partnership_reason;
+ALTER TABLE partnerships ADD CONSTRAINT check_partnership_reason CHECK (reason IN ('unpartnered', 'moderated', 'blocked', 'deleted'));
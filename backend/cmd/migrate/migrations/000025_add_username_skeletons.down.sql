@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_username_skeleton_key;
+
+ALTER TABLE users DROP COLUMN IF EXISTS username_skeleton;
@@ -0,0 +1,23 @@
+-- usernames that look alike share a skeleton, which is unique like the
+-- username. This folds the way store.UsernameSkeleton does.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_skeleton VARCHAR(255);
+
+-- of the users who already look alike, only the oldest gets the skeleton,
+-- the others get theirs once they rename
+WITH skeletons AS (
+  SELECT id, replace(replace(translate(
+    lower(normalize(btrim(username), NFKC)),
+    'авсԁеһіјкӏмнорԛгѕтѵԝхуαβεηικνορτυχγi0135._-',
+    'abcdehljklmhopqrstvwxyabenlkvoptuxyloles'
+  ), 'rn', 'm'), 'vv', 'w') AS skeleton
+  FROM users
+), ranked AS (
+  SELECT id, skeleton, ROW_NUMBER() OVER (PARTITION BY skeleton ORDER BY id) AS n
+  FROM skeletons
+)
+UPDATE users u
+SET username_skeleton = r.skeleton
+FROM ranked r
+WHERE r.id = u.id AND r.n = 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_skeleton_key ON users (username_skeleton);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_security_events_unknown_email;
+
+DELETE FROM security_events WHERE user_id IS NULL;
+
+ALTER TABLE security_events DROP COLUMN IF EXISTS email;
+ALTER TABLE security_events ALTER COLUMN user_id SET NOT NULL;
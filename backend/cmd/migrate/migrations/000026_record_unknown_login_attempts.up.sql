@@ -0,0 +1,6 @@
+-- failed attempts on emails without an account, or with unknown API keys,
+-- are recorded without a user
+ALTER TABLE security_events ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE security_events ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_security_events_unknown_email ON security_events (email, created_at) WHERE user_id IS NULL;
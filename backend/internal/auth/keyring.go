@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+
+	rsaKeyBits = 2048
+)
+
+var ErrNoSigningKey = errors.New("key ring has no key to sign with")
+
+// SigningKey is a private key of the key ring. Keys sign until RetiresAt and
+// verify until ExpiresAt, a zero time means never.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetiresAt time.Time
+	ExpiresAt time.Time
+}
+
+// GenerateSigningKey creates a new key for alg with a random key ID.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Algorithm: alg,
+		Private:   private,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// ParseSigningKey reads a PEM encoded PKCS #8 private key, or a PKCS #1 RSA
+// private key. The algorithm follows from the key type.
+func ParseSigningKey(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", id)
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ID: id, Algorithm: AlgRS256, Private: private}, nil
+	}
+
+	return UnmarshalSigningKey(id, block.Bytes)
+}
+
+// UnmarshalSigningKey reads a DER encoded PKCS #8 private key.
+func UnmarshalSigningKey(id string, der []byte) (*SigningKey, error) {
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: AlgEdDSA, Private: private}, nil
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: AlgRS256, Private: private}, nil
+	default:
+		return nil, fmt.Errorf("key %s has an unsupported type %T", id, private)
+	}
+}
+
+// MarshalPrivateKey returns the DER encoded PKCS #8 form of the key.
+func (k *SigningKey) MarshalPrivateKey() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.Private)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+func (k *SigningKey) signs(now time.Time) bool {
+	return k.RetiresAt.IsZero() || now.Before(k.RetiresAt)
+}
+
+func (k *SigningKey) verifies(now time.Time) bool {
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
+
+// JWK is the public half of a signing key, as published in a JWKS.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyRing is an Authenticator that signs with one of several keys and
+// verifies with any of them, picked by the kid header of the token.
+//
+// A new key only starts signing once it is older than propagation, which
+// gives every API instance time to load it before the first token signed by
+// it shows up.
+type KeyRing struct {
+	aud         string
+	iss         string
+	propagation time.Duration
+
+	mu   sync.RWMutex
+	keys map[string]*SigningKey
+}
+
+func NewKeyRing(aud, iss string, propagation time.Duration) *KeyRing {
+	return &KeyRing{
+		aud:         aud,
+		iss:         iss,
+		propagation: propagation,
+		keys:        make(map[string]*SigningKey),
+	}
+}
+
+// Set replaces the keys of the ring.
+func (k *KeyRing) Set(keys []*SigningKey) {
+	ring := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		ring[key.ID] = key
+	}
+
+	k.mu.Lock()
+	k.keys = ring
+	k.mu.Unlock()
+}
+
+// signingKey is the newest key that signs and has propagated, or the newest
+// key that signs when none has propagated yet.
+func (k *KeyRing) signingKey() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+
+	var newest, propagated *SigningKey
+	for _, key := range k.keys {
+		if !key.signs(now) {
+			continue
+		}
+
+		if newest == nil || key.CreatedAt.After(newest.CreatedAt) {
+			newest = key
+		}
+
+		if now.Sub(key.CreatedAt) >= k.propagation &&
+			(propagated == nil || key.CreatedAt.After(propagated.CreatedAt)) {
+			propagated = key
+		}
+	}
+
+	if propagated != nil {
+		return propagated
+	}
+	return newest
+}
+
+func (k *KeyRing) GenerateToken(claims jwt.Claims) (string, error) {
+	key := k.signingKey()
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+func (k *KeyRing) ValidateToken(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		k.mu.RLock()
+		key, ok := k.keys[kid]
+		k.mu.RUnlock()
+
+		if !ok || !key.verifies(time.Now()) {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+
+		return key.Private.Public(), nil
+	},
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(k.aud),
+		jwt.WithIssuer(k.iss),
+		jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256}),
+	)
+}
+
+// JWKS returns the public keys of every key that still verifies.
+func (k *KeyRing) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range k.keys {
+		if !key.verifies(now) {
+			continue
+		}
+
+		jwk := JWK{Use: "sig", KeyID: key.ID, Algorithm: key.Algorithm}
+
+		switch public := key.Private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DevMailer never delivers mail. Messages are kept in an in-memory outbox
+// and, when dir is set, written to that directory as .eml files.
+type DevMailer struct {
+	fromEmail string
+	dir       string
+
+	mu     sync.Mutex
+	outbox []Message
+}
+
+func NewDevMailer(fromEmail, dir string) *DevMailer {
+	return &DevMailer{
+		fromEmail: fromEmail,
+		dir:       dir,
+	}
+}
+
+func (m *DevMailer) Send(templateFile, username, email string, data any) error {
+	msg, err := render(m.fromEmail, templateFile, email, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.outbox = append(m.outbox, *msg)
+	m.mu.Unlock()
+
+	if m.dir == "" {
+		return nil
+	}
+
+	body, err := msg.encode(username)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), email)
+
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o644)
+}
+
+// Outbox returns a copy of every message sent so far.
+func (m *DevMailer) Outbox() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	outbox := make([]Message, len(m.outbox))
+	copy(outbox, m.outbox)
+
+	return outbox
+}
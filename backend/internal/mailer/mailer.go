@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+const (
+	FromName              = "PingU"
+	maxRetries            = 3
+	UserWelcomeTemplate   = "user_invitation.tmpl"
+	PasswordResetTemplate = "password_reset.tmpl"
+	AccountLockedTemplate = "account_locked.tmpl"
+	MagicLinkTemplate     = "magic_link.tmpl"
+	NewDeviceTemplate     = "new_device.tmpl"
+	EmailChangeTemplate   = "email_change.tmpl"
+	EmailNoticeTemplate   = "email_change_notice.tmpl"
+	PartnerInviteTemplate = "partner_invitation.tmpl"
+)
+
+//go:embed "templates"
+var FS embed.FS
+
+type Mailer interface {
+	Send(templateFile, username, email string, data any) error
+}
+
+// Message is a rendered email ready to be delivered.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// render builds a message from the "subject", "plainBody" and "htmlBody"
+// templates defined in templateFile.
+func render(from, templateFile, email string, data any) (*Message, error) {
+	textTmpl, err := texttemplate.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := textTmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	if err := textTmpl.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := htmltemplate.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	if err := htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:      from,
+		To:        email,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
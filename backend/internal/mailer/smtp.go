@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+type SMTPMailer struct {
+	fromEmail string
+	host      string
+	port      int
+	username  string
+	password  string
+}
+
+func NewSMTPMailer(fromEmail, host string, port int, username, password string) *SMTPMailer {
+	return &SMTPMailer{
+		fromEmail: fromEmail,
+		host:      host,
+		port:      port,
+		username:  username,
+		password:  password,
+	}
+}
+
+func (m *SMTPMailer) Send(templateFile, username, email string, data any) error {
+	msg, err := render(m.fromEmail, templateFile, email, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.encode(username)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	for i := 0; i < maxRetries; i++ {
+		err = smtp.SendMail(addr, auth, m.fromEmail, []string{email}, body)
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Failed to send email to %v, attempt %d of %d", email, i+1, maxRetries)
+		log.Printf("Error: %v", err.Error())
+
+		// back off before retrying
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+
+	return fmt.Errorf("failed to send email after %d attempts, error: %v", maxRetries, err)
+}
+
+// encode renders the message as a multipart/alternative MIME email.
+func (msg *Message) encode(username string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+
+	from := mail.Address{Name: FromName, Address: msg.From}
+	to := mail.Address{Name: username, Address: msg.To}
+
+	fmt.Fprintf(buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, p := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := part.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
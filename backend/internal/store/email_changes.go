@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type EmailChangeStore struct {
+	db *sql.DB
+}
+
+// Create stages a change of the user's email to email, replacing any change
+// the user hasn't confirmed yet.
+func (s *EmailChangeStore) Create(ctx context.Context, userID int64, email, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO pending_email_changes (token, user_id, new_email, expiry)
+		VALUES (decode($1, 'hex'), $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET token = EXCLUDED.token, new_email = EXCLUDED.new_email, expiry = EXCLUDED.expiry, created_at = NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, email, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Confirm switches the user owning the token to the new email, which the
+// confirmation proves is verified.
+func (s *EmailChangeStore) Confirm(ctx context.Context, token string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			DELETE FROM pending_email_changes
+			WHERE token = decode($1, 'hex')
+			RETURNING user_id, new_email, expiry
+		`
+
+		var userID int64
+		var email string
+		var expiry time.Time
+		err := tx.QueryRowContext(ctx, query, token).Scan(&userID, &email, &expiry)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if time.Now().After(expiry) {
+			return ErrTokenExpired
+		}
+
+		query = `
+			UPDATE users
+			SET email = $1, verified = TRUE, updated_at = NOW()
+			WHERE id = $2
+		`
+
+		if _, err := tx.ExecContext(ctx, query, email, userID); err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	ThrottleScopeEmail = "email"
+	ThrottleScopeIP    = "ip"
+)
+
+// LockoutPolicy decides when repeated login failures lock a scope out, and
+// for how long. Every failure past Threshold doubles the lockout, up to
+// MaxLockout. Failures older than Window are forgotten.
+type LockoutPolicy struct {
+	Threshold   int
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+// LoginThrottle counts the failed logins of an email or an IP.
+type LoginThrottle struct {
+	Scope        string       `json:"scope"`
+	Key          string       `json:"key"`
+	Failures     int          `json:"failures"`
+	LastFailedAt time.Time    `json:"last_failed_at"`
+	LockedUntil  sql.NullTime `json:"locked_until"`
+}
+
+type LoginThrottleStore struct {
+	db *sql.DB
+}
+
+// LockedUntil returns the furthest lockout of the email and the IP, or the
+// zero time when neither is locked.
+func (s *LoginThrottleStore) LockedUntil(ctx context.Context, email, ip string) (time.Time, error) {
+	query := `
+		SELECT MAX(locked_until)
+		FROM login_throttles
+		WHERE ((scope = $1 AND key = $2) OR (scope = $3 AND key = $4))
+			AND locked_until > NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		ThrottleScopeEmail,
+		email,
+		ThrottleScopeIP,
+		ip,
+	).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil.Time, nil
+}
+
+// RecordFailure counts a failed login against the scope and locks it out
+// once the policy threshold is reached.
+func (s *LoginThrottleStore) RecordFailure(ctx context.Context, scope, key string, policy LockoutPolicy) (*LoginThrottle, error) {
+	throttle := &LoginThrottle{Scope: scope, Key: key}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO login_throttles (scope, key, failures, last_failed_at)
+			VALUES ($1, $2, 1, NOW())
+			ON CONFLICT (scope, key) DO UPDATE
+			SET failures = CASE
+					WHEN login_throttles.last_failed_at < NOW() - make_interval(secs => $3) THEN 1
+					ELSE login_throttles.failures + 1
+				END,
+				last_failed_at = NOW()
+			RETURNING failures, last_failed_at
+		`
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			scope,
+			key,
+			policy.Window.Seconds(),
+		).Scan(
+			&throttle.Failures,
+			&throttle.LastFailedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		if throttle.Failures < policy.Threshold {
+			return nil
+		}
+
+		lockout := policy.BaseLockout
+		for i := policy.Threshold; i < throttle.Failures && lockout < policy.MaxLockout; i++ {
+			lockout *= 2
+		}
+		lockout = min(lockout, policy.MaxLockout)
+
+		query = `
+			UPDATE login_throttles
+			SET locked_until = $3
+			WHERE scope = $1 AND key = $2
+			RETURNING locked_until
+		`
+
+		return tx.QueryRowContext(
+			ctx,
+			query,
+			scope,
+			key,
+			throttle.LastFailedAt.Add(lockout),
+		).Scan(&throttle.LockedUntil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return throttle, nil
+}
+
+// Clear forgets the failed logins of the scope, lifting any lockout.
+func (s *LoginThrottleStore) Clear(ctx context.Context, scope, key string) error {
+	query := `
+		DELETE FROM login_throttles
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, scope, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
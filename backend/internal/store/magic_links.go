@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type MagicLinkStore struct {
+	db *sql.DB
+}
+
+// Create stores a hashed magic link token for the user.
+func (s *MagicLinkStore) Create(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO magic_links (token, user_id, expiry)
+		VALUES (decode($1, 'hex'), $2, $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Consume deletes the magic link so it can't be followed twice and returns
+// the ID of the user it signs in.
+func (s *MagicLinkStore) Consume(ctx context.Context, token string) (int64, error) {
+	query := `
+		DELETE FROM magic_links
+		WHERE token = decode($1, 'hex')
+		RETURNING user_id, expiry
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	var expiry time.Time
+	err := s.db.QueryRowContext(ctx, query, token).Scan(&userID, &expiry)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	if time.Now().After(expiry) {
+		return 0, ErrTokenExpired
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PartnerEmailInvite struct {
+	ID        int64     `json:"id"`
+	InviterID int64     `json:"inviter_id"`
+	Email     string    `json:"email"`
+	Expiry    time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PartnerEmailInviteStore struct {
+	db *sql.DB
+}
+
+// Create stores an invite of the inviter to an email without an account. An
+// invite to the same email is renewed instead.
+func (s *PartnerEmailInviteStore) Create(ctx context.Context, invite *PartnerEmailInvite) error {
+	query := `
+		INSERT INTO partner_email_invites (inviter_id, email, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (inviter_id, email) DO UPDATE
+		SET expiry = EXCLUDED.expiry, created_at = NOW()
+		RETURNING id, email, expiry, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		invite.InviterID,
+		invite.Email,
+		invite.Expiry,
+	).Scan(
+		&invite.ID,
+		&invite.Email,
+		&invite.Expiry,
+		&invite.CreatedAt,
+	)
+}
+
+// GetByInviterID returns the invites of the inviter that haven't expired.
+func (s *PartnerEmailInviteStore) GetByInviterID(ctx context.Context, inviterID int64) ([]PartnerEmailInvite, error) {
+	query := `
+		SELECT id, inviter_id, email, expiry, created_at
+		FROM partner_email_invites
+		WHERE inviter_id = $1 AND expiry > NOW()
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, inviterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []PartnerEmailInvite{}
+	for rows.Next() {
+		var invite PartnerEmailInvite
+		err := rows.Scan(
+			&invite.ID,
+			&invite.InviterID,
+			&invite.Email,
+			&invite.Expiry,
+			&invite.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// Delete cancels an invite of the inviter.
+func (s *PartnerEmailInviteStore) Delete(ctx context.Context, inviterID, id int64) error {
+	query := `DELETE FROM partner_email_invites WHERE id = $1 AND inviter_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, inviterID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpired removes up to limit expired invites and returns how many it
+// removed.
+func (s *PartnerEmailInviteStore) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	query := `
+		DELETE FROM partner_email_invites
+		WHERE id IN (
+			SELECT id
+			FROM partner_email_invites
+			WHERE expiry < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// pairInvitedUser partners a user who just activated their account with the
+// first user who invited their email before it registered and is still
+// without a partner. Later invites were never mailed, so they don't pair. The
+// invites to the email are used up, as are the other invites of the inviter.
+func pairInvitedUser(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `
+		SELECT pei.inviter_id
+		FROM partner_email_invites pei
+		WHERE pei.email = $1 AND pei.expiry > NOW() AND pei.inviter_id != $2 AND pei.created_at < $3 AND NOT EXISTS (
+			SELECT 1
+			FROM partnerships p
+			WHERE p.ended_at IS NULL AND pei.inviter_id IN (p.user_a, p.user_b)
+		)
+		ORDER BY pei.created_at
+		LIMIT 1
+		FOR UPDATE
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var inviterID int64
+	err := tx.QueryRowContext(ctx, query, user.Email, user.ID, user.CreatedAt).Scan(&inviterID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	// the inviter may have been partnered, or blocked the user, since the
+	// invite was read, which shouldn't hold up the activation
+	if err := linkPartners(ctx, tx, inviterID, user.ID); err != nil {
+		switch err {
+		case ErrAlreadyPartnered, ErrNotFound:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	query = `DELETE FROM partner_email_invites WHERE email = $1 OR inviter_id = $2`
+	if _, err := tx.ExecContext(ctx, query, user.Email, inviterID); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM partner_invites WHERE inviter_id = $1`
+	if _, err := tx.ExecContext(ctx, query, inviterID); err != nil {
+		return err
+	}
+
+	if err := cancelPendingPartnerRequests(ctx, tx, inviterID, user.ID); err != nil {
+		return err
+	}
+
+	user.PartnerID = sql.NullInt64{Int64: inviterID, Valid: true}
+
+	return nil
+}
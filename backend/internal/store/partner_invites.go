@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrSelfInvite = errors.New("users cannot redeem their own partner invite")
+
+type PartnerInviteStore struct {
+	db *sql.DB
+}
+
+// Create stores a hashed partner invite code of the inviter.
+func (s *PartnerInviteStore) Create(ctx context.Context, inviterID int64, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO partner_invites (token, inviter_id, expiry)
+		VALUES (decode($1, 'hex'), $2, $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, inviterID, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Redeem partners the redeemer with the inviter of the code. Every other
+// code of the inviter is revoked, and pending partner requests of both users
+// are cancelled. It returns the inviter's ID.
+func (s *PartnerInviteStore) Redeem(ctx context.Context, token string, redeemerID int64) (int64, error) {
+	var inviterID int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			SELECT inviter_id, expiry
+			FROM partner_invites
+			WHERE token = decode($1, 'hex')
+			FOR UPDATE
+		`
+
+		var expiry time.Time
+		err := tx.QueryRowContext(ctx, query, token).Scan(&inviterID, &expiry)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if time.Now().After(expiry) {
+			return ErrTokenExpired
+		}
+
+		if inviterID == redeemerID {
+			return ErrSelfInvite
+		}
+
+		if err := linkPartners(ctx, tx, inviterID, redeemerID); err != nil {
+			return err
+		}
+
+		query = `DELETE FROM partner_invites WHERE inviter_id = $1`
+		if _, err := tx.ExecContext(ctx, query, inviterID); err != nil {
+			return err
+		}
+
+		return cancelPendingPartnerRequests(ctx, tx, inviterID, redeemerID)
+	})
+
+	return inviterID, err
+}
+
+// DeleteExpired removes up to limit expired invite codes and returns how many
+// it removed.
+func (s *PartnerInviteStore) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	query := `
+		DELETE FROM partner_invites
+		WHERE token IN (
+			SELECT token
+			FROM partner_invites
+			WHERE expiry < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
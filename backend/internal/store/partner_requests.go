@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	PartnerRequestPending   = "pending"
+	PartnerRequestAccepted  = "accepted"
+	PartnerRequestDeclined  = "declined"
+	PartnerRequestCancelled = "cancelled"
+	PartnerRequestExpired   = "expired"
+)
+
+var (
+	ErrDuplicatePartnerRequest = errors.New("a pending partner request to that user already exists")
+	ErrPartnerRequestClosed    = errors.New("partner request is no longer pending")
+	ErrPartnerRequestExpired   = errors.New("partner request has expired")
+)
+
+// PartnerRequest asks the recipient to become the sender's partner. Nobody
+// is partnered until the recipient accepts.
+type PartnerRequest struct {
+	ID          int64        `json:"id"`
+	SenderID    int64        `json:"sender_id"`
+	RecipientID int64        `json:"recipient_id"`
+	Status      string       `json:"status"`
+	Expiry      time.Time    `json:"expires_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	RespondedAt sql.NullTime `json:"responded_at"`
+}
+
+type PartnerRequestStore struct {
+	db *sql.DB
+}
+
+func (s *PartnerRequestStore) Create(ctx context.Context, request *PartnerRequest) error {
+	query := `
+		INSERT INTO partner_requests (sender_id, recipient_id, expiry)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+		RETURNING id, status, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		request.SenderID,
+		request.RecipientID,
+		request.Expiry,
+	).Scan(
+		&request.ID,
+		&request.Status,
+		&request.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		// a block looks like a missing recipient, so the blocked user can't tell
+		case err == sql.ErrNoRows:
+			return ErrNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_partner_requests_pending"`:
+			return ErrDuplicatePartnerRequest
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetPending returns the pending requests the user received and sent, newest
+// first.
+func (s *PartnerRequestStore) GetPending(ctx context.Context, userID int64) (incoming, outgoing []PartnerRequest, err error) {
+	query := `
+		SELECT id, sender_id, recipient_id, status, expiry, created_at, responded_at
+		FROM partner_requests
+		WHERE (sender_id = $1 OR recipient_id = $1) AND status = $2 AND expiry > NOW()
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, PartnerRequestPending)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	incoming, outgoing = []PartnerRequest{}, []PartnerRequest{}
+	for rows.Next() {
+		var request PartnerRequest
+		err := rows.Scan(
+			&request.ID,
+			&request.SenderID,
+			&request.RecipientID,
+			&request.Status,
+			&request.Expiry,
+			&request.CreatedAt,
+			&request.RespondedAt,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if request.RecipientID == userID {
+			incoming = append(incoming, request)
+		} else {
+			outgoing = append(outgoing, request)
+		}
+	}
+
+	return incoming, outgoing, rows.Err()
+}
+
+// Accept partners the recipient with the sender of the request. Every other
+// pending request of either user is cancelled, since neither can take
+// another partner anymore.
+func (s *PartnerRequestStore) Accept(ctx context.Context, id, recipientID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		request, err := getPendingPartnerRequest(ctx, tx, id, "recipient_id", recipientID)
+		if err != nil {
+			return err
+		}
+
+		if err := linkPartners(ctx, tx, request.SenderID, request.RecipientID); err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE partner_requests
+			SET status = $1, responded_at = NOW()
+			WHERE id = $2
+		`
+
+		if _, err := tx.ExecContext(ctx, query, PartnerRequestAccepted, id); err != nil {
+			return err
+		}
+
+		return cancelPendingPartnerRequests(ctx, tx, request.SenderID, request.RecipientID)
+	})
+}
+
+// cancelPendingPartnerRequests closes every pending request sent or received
+// by two users who were just partnered.
+func cancelPendingPartnerRequests(ctx context.Context, tx *sql.Tx, userID, partnerID int64) error {
+	query := `
+		UPDATE partner_requests
+		SET status = $1, responded_at = NOW()
+		WHERE status = $2 AND (sender_id IN ($3, $4) OR recipient_id IN ($3, $4))
+	`
+
+	_, err := tx.ExecContext(ctx, query, PartnerRequestCancelled, PartnerRequestPending, userID, partnerID)
+	return err
+}
+
+// Decline closes a request the user received.
+func (s *PartnerRequestStore) Decline(ctx context.Context, id, recipientID int64) error {
+	return s.close(ctx, id, "recipient_id", recipientID, PartnerRequestDeclined)
+}
+
+// Cancel closes a request the user sent.
+func (s *PartnerRequestStore) Cancel(ctx context.Context, id, senderID int64) error {
+	return s.close(ctx, id, "sender_id", senderID, PartnerRequestCancelled)
+}
+
+// close moves a pending request of the user to status. column names the side
+// of the request the user is on.
+func (s *PartnerRequestStore) close(ctx context.Context, id int64, column string, userID int64, status string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := getPendingPartnerRequest(ctx, tx, id, column, userID); err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE partner_requests
+			SET status = $1, responded_at = NOW()
+			WHERE id = $2
+		`
+
+		_, err := tx.ExecContext(ctx, query, status, id)
+		return err
+	})
+}
+
+// getPendingPartnerRequest locks the request with id, as long as the user is
+// on the column side of it and it is still pending.
+func getPendingPartnerRequest(ctx context.Context, tx *sql.Tx, id int64, column string, userID int64) (*PartnerRequest, error) {
+	query := `
+		SELECT id, sender_id, recipient_id, status, expiry, created_at, responded_at
+		FROM partner_requests
+		WHERE id = $1 AND ` + column + ` = $2
+		FOR UPDATE
+	`
+
+	var request PartnerRequest
+	err := tx.QueryRowContext(ctx, query, id, userID).Scan(
+		&request.ID,
+		&request.SenderID,
+		&request.RecipientID,
+		&request.Status,
+		&request.Expiry,
+		&request.CreatedAt,
+		&request.RespondedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if request.Status != PartnerRequestPending {
+		return nil, ErrPartnerRequestClosed
+	}
+
+	if time.Now().After(request.Expiry) {
+		return nil, ErrPartnerRequestExpired
+	}
+
+	return &request, nil
+}
+
+// ExpireStale marks up to limit pending requests past their expiry as
+// expired and returns how many it marked.
+func (s *PartnerRequestStore) ExpireStale(ctx context.Context, limit int) (int64, error) {
+	query := `
+		UPDATE partner_requests
+		SET status = $1
+		WHERE id IN (
+			SELECT id
+			FROM partner_requests
+			WHERE status = $2 AND expiry < NOW()
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, PartnerRequestExpired, PartnerRequestPending, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
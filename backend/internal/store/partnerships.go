@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Reasons a partnership ended.
+const (
+	PartnershipUnpartnered = "unpartnered"
+	PartnershipModerated   = "moderated"
+	PartnershipBlocked     = "blocked"
+	PartnershipDeleted     = "deleted"
+)
+
+// Partnership is a pairing of two users, seen from one of them. The ping
+// counters only count pings within the partnership. The partner is null, and
+// their username empty, once they deleted their account.
+type Partnership struct {
+	ID              int64         `json:"id"`
+	PartnerID       sql.NullInt64 `json:"partner_id"`
+	PartnerUsername string        `json:"partner_username"`
+	StartedAt       time.Time     `json:"started_at"`
+	EndedAt         sql.NullTime  `json:"ended_at"`
+	EndedBy         sql.NullInt64 `json:"ended_by"`
+	Reason          string        `json:"reason,omitempty"`
+	PingsSent       int64         `json:"pings_sent"`
+	PingsReceived   int64         `json:"pings_received"`
+	PongsSent       int64         `json:"pongs_sent"`
+	PongsReceived   int64         `json:"pongs_received"`
+}
+
+type PartnershipStore struct {
+	db *sql.DB
+}
+
+// GetByUserID returns the current and past partnerships of the user, newest
+// first.
+func (s *PartnershipStore) GetByUserID(ctx context.Context, userID int64) ([]Partnership, error) {
+	query := `
+		SELECT p.id, u.id, COALESCE(u.username, ''), p.started_at, p.ended_at, p.ended_by, COALESCE(p.reason, ''),
+			CASE WHEN p.user_a = $1 THEN p.user_a_pings ELSE p.user_b_pings END,
+			CASE WHEN p.user_a = $1 THEN p.user_b_pings ELSE p.user_a_pings END,
+			CASE WHEN p.user_a = $1 THEN p.user_a_pongs ELSE p.user_b_pongs END,
+			CASE WHEN p.user_a = $1 THEN p.user_b_pongs ELSE p.user_a_pongs END
+		FROM partnerships p
+		LEFT JOIN users u ON u.id = CASE WHEN p.user_a = $1 THEN p.user_b ELSE p.user_a END
+		WHERE p.user_a = $1 OR p.user_b = $1
+		ORDER BY p.started_at DESC, p.id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partnerships := []Partnership{}
+	for rows.Next() {
+		var p Partnership
+		err := rows.Scan(
+			&p.ID,
+			&p.PartnerID,
+			&p.PartnerUsername,
+			&p.StartedAt,
+			&p.EndedAt,
+			&p.EndedBy,
+			&p.Reason,
+			&p.PingsSent,
+			&p.PingsReceived,
+			&p.PongsSent,
+			&p.PongsReceived,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		partnerships = append(partnerships, p)
+	}
+
+	return partnerships, rows.Err()
+}
+
+// linkPartners starts a partnership between two users. It fails with
+// ErrAlreadyPartnered, and changes nothing, when either of them already has a
+// partner. When either of them blocked the other it fails with ErrNotFound,
+// so the blocked user can't tell.
+func linkPartners(ctx context.Context, tx *sql.Tx, userID, partnerID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if err := lockUserPair(ctx, tx, userID, partnerID); err != nil {
+		return err
+	}
+
+	// a block takes the same locks, so it can't slip in between the check and
+	// the partnership
+	blocked, err := isBlocked(ctx, tx, userID, partnerID)
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return ErrNotFound
+	}
+
+	query := `
+		INSERT INTO partnerships (user_a, user_b)
+		SELECT $1, $2
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM partnerships
+			WHERE ended_at IS NULL AND (user_a IN ($1, $2) OR user_b IN ($1, $2))
+		)
+	`
+
+	res, err := tx.ExecContext(ctx, query, userID, partnerID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return ErrAlreadyPartnered
+	}
+
+	return nil
+}
+
+// lockUserPair locks the rows of two users, in a fixed order so that two
+// transactions locking the same pair can't deadlock. Whatever partners or
+// blocks the pair takes these locks first. It fails with ErrNotFound when
+// either user doesn't exist.
+func lockUserPair(ctx context.Context, tx *sql.Tx, userID, otherID int64) error {
+	query := `SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, userID, otherID)
+	if err != nil {
+		return err
+	}
+
+	locked := 0
+	for rows.Next() {
+		locked++
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if locked != 2 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// endPartnership ends the current partnership between two users and clears
+// their pinged status. It fails with ErrPartnerNotFound when they aren't
+// partnered.
+func endPartnership(ctx context.Context, tx *sql.Tx, userID, partnerID, endedBy int64, reason string) error {
+	query := `
+		UPDATE partnerships
+		SET ended_at = NOW(), ended_by = $3, reason = $4
+		WHERE ended_at IS NULL AND (
+			(user_a = $1 AND user_b = $2) OR (user_a = $2 AND user_b = $1)
+		)
+	`
+
+	res, err := tx.ExecContext(ctx, query, userID, partnerID, endedBy, reason)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrPartnerNotFound
+	}
+
+	query = `
+		UPDATE users
+		SET pinged = false, updated_at = NOW()
+		WHERE id IN ($1, $2)
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID, partnerID)
+	return err
+}
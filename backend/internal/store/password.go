@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrMismatchedPassword = errors.New("password does not match")
+
+// Argon2Params are the cost parameters of Argon2id password hashes.
+type Argon2Params struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2Params checks configured cost parameters before anything is
+// hashed with them, argon2 panics on some of them. Salts are 16 bytes and
+// keys 32.
+func NewArgon2Params(memory, iterations, parallelism int) (Argon2Params, error) {
+	params := Argon2Params{SaltLength: 16, KeyLength: 32}
+
+	if parallelism < 1 || parallelism > math.MaxUint8 {
+		return params, fmt.Errorf("argon2 parallelism must be between 1 and %d, got %d", math.MaxUint8, parallelism)
+	}
+
+	if iterations < 1 || int64(iterations) > math.MaxUint32 {
+		return params, fmt.Errorf("argon2 iterations must be between 1 and %d, got %d", uint32(math.MaxUint32), iterations)
+	}
+
+	// argon2 needs 8 KiB per lane
+	if memory < 8*parallelism || int64(memory) > math.MaxUint32 {
+		return params, fmt.Errorf("argon2 memory must be between %d and %d KiB, got %d", 8*parallelism, uint32(math.MaxUint32), memory)
+	}
+
+	params.Memory = uint32(memory)
+	params.Iterations = uint32(iterations)
+	params.Parallelism = uint8(parallelism)
+
+	return params, nil
+}
+
+// valid reports whether argon2 can hash with the parameters, which hashes
+// read back from the database are checked for too.
+func (p *Argon2Params) valid() bool {
+	return p.Parallelism >= 1 &&
+		p.Iterations >= 1 &&
+		p.Memory >= 8*uint32(p.Parallelism) &&
+		p.KeyLength >= 1
+}
+
+// Hashes are stored in their encoded form, the prefix names the algorithm:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//	$2a$10$<bcrypt salt and hash>
+const argon2idPrefix = "$argon2id$"
+
+type password struct {
+	text *string
+	hash []byte
+}
+
+// Set hashes text with Argon2id using params, which come from
+// NewArgon2Params.
+func (p *password) Set(text string, params Argon2Params) error {
+	if !params.valid() {
+		return errors.New("invalid argon2 parameters")
+	}
+
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(text), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	encoded := fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	p.text = &text
+	p.hash = []byte(encoded)
+
+	return nil
+}
+
+// Compare checks a plain text password against the stored hash, whichever
+// algorithm made it.
+func (p *password) Compare(text string) error {
+	if !bytes.HasPrefix(p.hash, []byte(argon2idPrefix)) {
+		return bcrypt.CompareHashAndPassword(p.hash, []byte(text))
+	}
+
+	params, salt, key, err := decodeArgon2id(p.hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(text), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedPassword
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the stored hash wasn't made with Argon2id and
+// want, the parameters new passwords are hashed with, so it should be
+// replaced the next time the plain text password is known.
+func (p *password) NeedsRehash(want Argon2Params) bool {
+	if !bytes.HasPrefix(p.hash, []byte(argon2idPrefix)) {
+		return true
+	}
+
+	params, salt, key, err := decodeArgon2id(p.hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != want.Memory ||
+		params.Iterations != want.Iterations ||
+		params.Parallelism != want.Parallelism ||
+		uint32(len(salt)) != want.SaltLength ||
+		uint32(len(key)) != want.KeyLength
+}
+
+func decodeArgon2id(hash []byte) (*Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var params Argon2Params
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	if !params.valid() {
+		return nil, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	return &params, salt, key, nil
+}
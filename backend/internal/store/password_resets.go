@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PasswordResetStore struct {
+	db *sql.DB
+}
+
+// Create stores a hashed password reset token for the user.
+func (s *PasswordResetStore) Create(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO password_resets (token, user_id, expiry)
+		VALUES (decode($1, 'hex'), $2, $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reset sets the password of the user owning the reset token and invalidates
+// every outstanding reset token of that user.
+func (s *PasswordResetStore) Reset(ctx context.Context, token string, user *User) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			SELECT user_id, expiry
+			FROM password_resets
+			WHERE token = decode($1, 'hex')
+		`
+
+		var expiry time.Time
+		err := tx.QueryRowContext(ctx, query, token).Scan(&user.ID, &expiry)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if time.Now().After(expiry) {
+			return ErrTokenExpired
+		}
+
+		query = `
+			UPDATE users
+			SET password = $1, updated_at = NOW()
+			WHERE id = $2
+			RETURNING updated_at
+		`
+
+		err = tx.QueryRowContext(ctx, query, user.Password.hash, user.ID).Scan(&user.UpdatedAt)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		query = `DELETE FROM password_resets WHERE user_id = $1`
+
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
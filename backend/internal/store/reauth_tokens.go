@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ReauthTokenStore keeps proof that a user just signed in again with their
+// identity provider, which stands in for the password of users who have none.
+type ReauthTokenStore struct {
+	db *sql.DB
+}
+
+// Create stores a hashed reauthentication token for the user.
+func (s *ReauthTokenStore) Create(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO reauth_tokens (token, user_id, expiry)
+		VALUES (decode($1, 'hex'), $2, $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Consume deletes the token of the user so it can't be used twice.
+func (s *ReauthTokenStore) Consume(ctx context.Context, token string, userID int64) error {
+	query := `
+		DELETE FROM reauth_tokens
+		WHERE token = decode($1, 'hex') AND user_id = $2
+		RETURNING expiry
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var expiry time.Time
+	err := s.db.QueryRowContext(ctx, query, token, userID).Scan(&expiry)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	if time.Now().After(expiry) {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+// DeleteExpired removes up to limit expired tokens and returns how many it
+// removed.
+func (s *ReauthTokenStore) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	query := `
+		DELETE FROM reauth_tokens
+		WHERE token IN (
+			SELECT token
+			FROM reauth_tokens
+			WHERE expiry < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodTwoFactor = "two_factor"
+	LoginMethodAPIKey    = "api_key"
+	LoginMethodOIDC      = "oidc"
+	LoginMethodWebAuthn  = "webauthn"
+	LoginMethodMagicLink = "magic_link"
+
+	LoginOutcomeSuccess    = "success"
+	LoginOutcomeFailure    = "failure"
+	LoginOutcomeChallenged = "challenged" // first factor passed, second pending
+)
+
+// SecurityEvent is a login attempt on a user's account. Fingerprint
+// identifies the device the attempt came from. Failed attempts that match no
+// user are kept too, with a zero UserID and the email they tried, if any.
+type SecurityEvent struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Email       string    `json:"-"`
+	Method      string    `json:"method"`
+	Outcome     string    `json:"outcome"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Browser     string    `json:"browser"`
+	OS          string    `json:"os"`
+	Device      string    `json:"device"`
+	Fingerprint string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type SecurityEventStore struct {
+	db *sql.DB
+}
+
+// Record stores the event and reports whether it is the first successful
+// login from its device. An event identical to one recorded less than dedupe
+// ago is dropped.
+func (s *SecurityEventStore) Record(ctx context.Context, event *SecurityEvent, dedupe time.Duration) (bool, error) {
+	newDevice := false
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if dedupe > 0 {
+			query := `
+				SELECT EXISTS (
+					SELECT 1
+					FROM security_events
+					WHERE user_id IS NOT DISTINCT FROM NULLIF($1, 0) AND email = $7 AND method = $2 AND outcome = $3 AND ip = $4
+						AND fingerprint = decode($5, 'hex') AND created_at > $6
+				)
+			`
+
+			var recent bool
+			err := tx.QueryRowContext(
+				ctx,
+				query,
+				event.UserID,
+				event.Method,
+				event.Outcome,
+				event.IP,
+				event.Fingerprint,
+				time.Now().Add(-dedupe),
+				event.Email,
+			).Scan(&recent)
+			if err != nil {
+				return err
+			}
+
+			if recent {
+				return nil
+			}
+		}
+
+		if event.Outcome == LoginOutcomeSuccess {
+			query := `
+				SELECT NOT EXISTS (
+					SELECT 1
+					FROM security_events
+					WHERE user_id = $1 AND fingerprint = decode($2, 'hex') AND outcome = $3
+				)
+			`
+
+			err := tx.QueryRowContext(ctx, query, event.UserID, event.Fingerprint, LoginOutcomeSuccess).Scan(&newDevice)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := `
+			INSERT INTO security_events (user_id, email, method, outcome, ip, user_agent, browser, os, device, fingerprint)
+			VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8, $9, decode($10, 'hex'))
+			RETURNING id, created_at
+		`
+
+		return tx.QueryRowContext(
+			ctx,
+			query,
+			event.UserID,
+			event.Email,
+			event.Method,
+			event.Outcome,
+			event.IP,
+			event.UserAgent,
+			event.Browser,
+			event.OS,
+			event.Device,
+			event.Fingerprint,
+		).Scan(&event.ID, &event.CreatedAt)
+	})
+
+	return newDevice, err
+}
+
+// GetByUserID returns up to limit events of the user, newest first, that
+// are older than the event with ID before. A zero before starts at the
+// newest event.
+func (s *SecurityEventStore) GetByUserID(ctx context.Context, userID, before int64, limit int) ([]SecurityEvent, error) {
+	query := `
+		SELECT id, user_id, method, outcome, ip, user_agent, browser, os, device, created_at
+		FROM security_events
+		WHERE user_id = $1 AND id < $2
+		ORDER BY id DESC
+		LIMIT $3
+	`
+
+	if before == 0 {
+		before = math.MaxInt64
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var event SecurityEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.UserID,
+			&event.Method,
+			&event.Outcome,
+			&event.IP,
+			&event.UserAgent,
+			&event.Browser,
+			&event.OS,
+			&event.Device,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionRevoked = errors.New("session has been revoked")
+	ErrTokenReused    = errors.New("refresh token has already been used")
+)
+
+// Session is a signed in device. Every rotation of its refresh token adds a
+// row to the session family, ID identifies the whole family.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Token      string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Expiry     time.Time `json:"expires_at"`
+	StartedAt  time.Time `json:"started_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+// Create starts a new session family for the user.
+func (s *SessionStore) Create(ctx context.Context, session *Session) error {
+	query := `
+		INSERT INTO sessions (family_id, user_id, token, user_agent, ip, expiry)
+		VALUES (gen_random_uuid(), $1, decode($2, 'hex'), $3, $4, $5)
+		RETURNING family_id, started_at, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.Token,
+		session.UserAgent,
+		session.IP,
+		session.Expiry,
+	).Scan(
+		&session.ID,
+		&session.StartedAt,
+		&session.LastUsedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Rotate exchanges a refresh token for next, which joins the same session
+// family. Presenting a token that was already rotated revokes the family.
+func (s *SessionStore) Rotate(ctx context.Context, token string, next *Session) error {
+	reused := false
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			SELECT id, family_id, user_id, expiry, started_at, rotated_at, revoked_at
+			FROM sessions
+			WHERE token = decode($1, 'hex')
+			FOR UPDATE
+		`
+
+		var (
+			id        int64
+			expiry    time.Time
+			rotatedAt sql.NullTime
+			revokedAt sql.NullTime
+		)
+		err := tx.QueryRowContext(ctx, query, token).Scan(
+			&id,
+			&next.ID,
+			&next.UserID,
+			&expiry,
+			&next.StartedAt,
+			&rotatedAt,
+			&revokedAt,
+		)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if revokedAt.Valid {
+			return ErrSessionRevoked
+		}
+
+		if rotatedAt.Valid {
+			// the token leaked, kill every device that descends from it
+			reused = true
+			return revokeFamily(ctx, tx, next.ID)
+		}
+
+		if time.Now().After(expiry) {
+			return ErrTokenExpired
+		}
+
+		query = `UPDATE sessions SET rotated_at = NOW() WHERE id = $1`
+
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO sessions (family_id, user_id, token, user_agent, ip, expiry, started_at)
+			VALUES ($1, $2, decode($3, 'hex'), $4, $5, $6, $7)
+			RETURNING created_at
+		`
+
+		return tx.QueryRowContext(
+			ctx,
+			query,
+			next.ID,
+			next.UserID,
+			next.Token,
+			next.UserAgent,
+			next.IP,
+			next.Expiry,
+			next.StartedAt,
+		).Scan(&next.LastUsedAt)
+	})
+	if err != nil {
+		return err
+	}
+
+	if reused {
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// GetByUserID lists the active sessions of the user.
+func (s *SessionStore) GetByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	query := `
+		SELECT family_id, user_id, user_agent, ip, expiry, started_at, created_at
+		FROM sessions
+		WHERE user_id = $1 AND rotated_at IS NULL AND revoked_at IS NULL AND expiry > NOW()
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.Expiry,
+			&session.StartedAt,
+			&session.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revoke ends one session of the user.
+func (s *SessionStore) Revoke(ctx context.Context, userID int64, id string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeByToken ends the session the refresh token belongs to.
+func (s *SessionStore) RevokeByToken(ctx context.Context, token string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND family_id = (
+			SELECT family_id FROM sessions WHERE token = decode($1, 'hex')
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAll ends every session of the user.
+func (s *SessionStore) RevokeAll(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := tx.ExecContext(ctx, query, familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
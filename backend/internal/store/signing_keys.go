@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// KeyRotationPolicy decides when a new signing key replaces the current one.
+// The replaced key keeps signing for Propagation, so every API instance can
+// load the new key first, and keeps verifying for Grace after that.
+type KeyRotationPolicy struct {
+	Every       time.Duration
+	Propagation time.Duration
+	Grace       time.Duration
+}
+
+// due reports whether a key created at newest has to be replaced, a missing
+// key always has to be.
+func (p KeyRotationPolicy) due(newest sql.NullTime) bool {
+	return !newest.Valid || time.Since(newest.Time) >= p.Every
+}
+
+// SigningKey is a private key used to sign access tokens. PrivateKey holds
+// the DER encoded PKCS #8 form of the key.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey []byte
+	CreatedAt  time.Time
+	RetiresAt  sql.NullTime
+	ExpiresAt  sql.NullTime
+}
+
+type SigningKeyStore struct {
+	db *sql.DB
+}
+
+// GetAll returns every signing key that still verifies, oldest first.
+func (s *SigningKeyStore) GetAll(ctx context.Context) ([]SigningKey, error) {
+	query := `
+		SELECT kid, algorithm, private_key, created_at, retires_at, expires_at
+		FROM signing_keys
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.CreatedAt,
+			&key.RetiresAt,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RotationDue reports whether Rotate would store a new key, so that one is
+// only generated when it is needed.
+func (s *SigningKeyStore) RotationDue(ctx context.Context, policy KeyRotationPolicy) (bool, error) {
+	query := `
+		SELECT MAX(created_at)
+		FROM signing_keys
+		WHERE retires_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var newest sql.NullTime
+	if err := s.db.QueryRowContext(ctx, query).Scan(&newest); err != nil {
+		return false, err
+	}
+
+	return policy.due(newest), nil
+}
+
+// Rotate stores next and retires the current keys when the newest key is
+// older than policy.Every, or when there is no key at all. Expired keys are
+// removed on the way. It reports whether next was stored.
+func (s *SigningKeyStore) Rotate(ctx context.Context, next *SigningKey, policy KeyRotationPolicy) (bool, error) {
+	rotated := false
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// every instance runs the rotation, only one of them may rotate
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		query := `DELETE FROM signing_keys WHERE expires_at <= NOW()`
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+
+		query = `
+			SELECT MAX(created_at)
+			FROM signing_keys
+			WHERE retires_at IS NULL
+		`
+
+		var newest sql.NullTime
+		if err := tx.QueryRowContext(ctx, query).Scan(&newest); err != nil {
+			return err
+		}
+
+		// another instance may have rotated since RotationDue was checked
+		if !policy.due(newest) {
+			return nil
+		}
+
+		retiresAt := time.Now().Add(policy.Propagation)
+
+		query = `
+			UPDATE signing_keys
+			SET retires_at = $1, expires_at = $2
+			WHERE retires_at IS NULL
+		`
+
+		_, err := tx.ExecContext(ctx, query, retiresAt, retiresAt.Add(policy.Grace))
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO signing_keys (kid, algorithm, private_key)
+			VALUES ($1, $2, $3)
+			RETURNING created_at
+		`
+
+		err = tx.QueryRowContext(ctx, query, next.ID, next.Algorithm, next.PrivateKey).Scan(&next.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		rotated = true
+		return nil
+	})
+
+	return rotated, err
+}
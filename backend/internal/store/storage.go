@@ -12,6 +12,7 @@ import (
 var (
 	ErrNotFound          = errors.New("resource not found")
 	ErrPartnerNotFound   = errors.New("partner not found")
+	ErrTokenExpired      = errors.New("token has expired")
 	QueryTimeoutDuration = time.Second * 5
 )
 
@@ -19,19 +20,143 @@ type Storage struct {
 	Users interface {
 		Create(context.Context, *sql.Tx, *User) error
 		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error
+		CreateWithIdentity(context.Context, *User, *Identity) error
+		Reinvite(ctx context.Context, userID int64, token string, exp time.Duration) error
+		Activate(ctx context.Context, token string) error
 		GetByID(context.Context, int64) (*User, error)
+		GetByEmail(context.Context, string) (*User, error)
 		Update(context.Context, *User) error
+		UpdatePassword(context.Context, *User) error
 		Delete(context.Context, int64) error
-		Partner(context.Context, *User, *User) error
-		Unpartner(context.Context, *User) error
+		DeleteExpiredInvitations(ctx context.Context, limit int) (int64, error)
+		DeleteUnverified(ctx context.Context, createdBefore time.Time, limit int) (int64, error)
+		Unpartner(ctx context.Context, user *User, endedBy int64, reason string) error
 		Ping(context.Context, *User) error
 		Pong(context.Context, *User) error
 	}
+	PasswordResets interface {
+		Create(ctx context.Context, userID int64, token string, exp time.Duration) error
+		Reset(ctx context.Context, token string, user *User) error
+	}
+	EmailChanges interface {
+		Create(ctx context.Context, userID int64, email, token string, exp time.Duration) error
+		Confirm(ctx context.Context, token string) error
+	}
+	MagicLinks interface {
+		Create(ctx context.Context, userID int64, token string, exp time.Duration) error
+		Consume(ctx context.Context, token string) (int64, error)
+	}
+	ReauthTokens interface {
+		Create(ctx context.Context, userID int64, token string, exp time.Duration) error
+		Consume(ctx context.Context, token string, userID int64) error
+		DeleteExpired(ctx context.Context, limit int) (int64, error)
+	}
+	APIKeys interface {
+		Create(context.Context, *APIKey) error
+		GetByToken(context.Context, string) (*APIKey, error)
+		GetByUserID(context.Context, int64) ([]APIKey, error)
+		Delete(ctx context.Context, userID, id int64) error
+	}
+	Identities interface {
+		GetByProviderSubject(ctx context.Context, provider, subject string) (*Identity, error)
+	}
+	LoginThrottles interface {
+		LockedUntil(ctx context.Context, email, ip string) (time.Time, error)
+		RecordFailure(ctx context.Context, scope, key string, policy LockoutPolicy) (*LoginThrottle, error)
+		Clear(ctx context.Context, scope, key string) error
+	}
+	TwoFactor interface {
+		GetTOTP(context.Context, int64) (*TOTP, error)
+		EnrollTOTP(ctx context.Context, userID int64, secret string) error
+		ConfirmTOTP(ctx context.Context, userID, step int64, recoveryCodes []string) error
+		UseTOTPStep(ctx context.Context, userID, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, code string) error
+		ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryCodes []string) error
+		Disable(context.Context, int64) error
+		CreateChallenge(ctx context.Context, userID int64, token string, exp time.Duration) error
+		AttemptChallenge(ctx context.Context, token string, maxAttempts int) (int64, error)
+		DeleteChallenge(context.Context, string) error
+	}
+	WebAuthn interface {
+		Create(context.Context, *WebAuthnCredential) error
+		GetByUserID(context.Context, int64) ([]WebAuthnCredential, error)
+		RecordLogin(ctx context.Context, credentialID []byte, signCount uint32, backupState bool) error
+		Rename(ctx context.Context, userID, id int64, name string) error
+		Delete(ctx context.Context, userID, id int64) error
+		CreateCeremony(ctx context.Context, userID int64, session []byte, exp time.Duration) (string, error)
+		ConsumeCeremony(ctx context.Context, id string, userID int64) ([]byte, error)
+		DeleteExpiredCeremonies(ctx context.Context, limit int) (int64, error)
+	}
+	SecurityEvents interface {
+		Record(ctx context.Context, event *SecurityEvent, dedupe time.Duration) (bool, error)
+		GetByUserID(ctx context.Context, userID, before int64, limit int) ([]SecurityEvent, error)
+	}
+	SigningKeys interface {
+		GetAll(context.Context) ([]SigningKey, error)
+		RotationDue(context.Context, KeyRotationPolicy) (bool, error)
+		Rotate(ctx context.Context, next *SigningKey, policy KeyRotationPolicy) (bool, error)
+	}
+	PartnerRequests interface {
+		Create(context.Context, *PartnerRequest) error
+		GetPending(ctx context.Context, userID int64) ([]PartnerRequest, []PartnerRequest, error)
+		Accept(ctx context.Context, id, recipientID int64) error
+		Decline(ctx context.Context, id, recipientID int64) error
+		Cancel(ctx context.Context, id, senderID int64) error
+		ExpireStale(ctx context.Context, limit int) (int64, error)
+	}
+	PartnerInvites interface {
+		Create(ctx context.Context, inviterID int64, token string, exp time.Duration) error
+		Redeem(ctx context.Context, token string, redeemerID int64) (int64, error)
+		DeleteExpired(ctx context.Context, limit int) (int64, error)
+	}
+	PartnerEmailInvites interface {
+		Create(context.Context, *PartnerEmailInvite) error
+		GetByInviterID(context.Context, int64) ([]PartnerEmailInvite, error)
+		Delete(ctx context.Context, inviterID, id int64) error
+		DeleteExpired(ctx context.Context, limit int) (int64, error)
+	}
+	UserBlocks interface {
+		Block(context.Context, *UserBlock) error
+		GetByBlockerID(context.Context, int64) ([]UserBlock, error)
+		Unblock(ctx context.Context, blockerID, blockedID int64) error
+	}
+	Partnerships interface {
+		GetByUserID(context.Context, int64) ([]Partnership, error)
+	}
+	Roles interface {
+		GetByName(context.Context, string) (*Role, error)
+	}
+	Sessions interface {
+		Create(context.Context, *Session) error
+		Rotate(ctx context.Context, token string, next *Session) error
+		GetByUserID(context.Context, int64) ([]Session, error)
+		Revoke(ctx context.Context, userID int64, id string) error
+		RevokeByToken(ctx context.Context, token string) error
+		RevokeAll(ctx context.Context, userID int64) error
+	}
 }
 
 func NewStorage(db *sql.DB) Storage {
 	return Storage{
-		Users: &UserStore{db},
+		Users:               &UserStore{db},
+		PasswordResets:      &PasswordResetStore{db},
+		MagicLinks:          &MagicLinkStore{db},
+		ReauthTokens:        &ReauthTokenStore{db},
+		EmailChanges:        &EmailChangeStore{db},
+		Sessions:            &SessionStore{db},
+		Roles:               &RoleStore{db},
+		APIKeys:             &APIKeyStore{db},
+		Identities:          &IdentityStore{db},
+		LoginThrottles:      &LoginThrottleStore{db},
+		TwoFactor:           &TwoFactorStore{db},
+		WebAuthn:            &WebAuthnStore{db},
+		SigningKeys:         &SigningKeyStore{db},
+		SecurityEvents:      &SecurityEventStore{db},
+		PartnerRequests:     &PartnerRequestStore{db},
+		PartnerInvites:      &PartnerInviteStore{db},
+		PartnerEmailInvites: &PartnerEmailInviteStore{db},
+		UserBlocks:          &UserBlockStore{db},
+		Partnerships:        &PartnershipStore{db},
 	}
 }
 
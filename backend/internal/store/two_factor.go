@@ -0,0 +1,288 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	ErrCodeReused       = errors.New("code has already been used")
+)
+
+type TOTP struct {
+	UserID       int64        `json:"user_id"`
+	Secret       string       `json:"-"`
+	LastUsedStep int64        `json:"-"`
+	ConfirmedAt  sql.NullTime `json:"confirmed_at"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// Enabled reports whether the enrollment was confirmed with a first code.
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt.Valid
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *TwoFactorStore) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, last_used_step, confirmed_at, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var totp TOTP
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.LastUsedStep,
+		&totp.ConfirmedAt,
+		&totp.CreatedAt,
+	)
+
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// EnrollTOTP stores a new, unconfirmed secret for the user, replacing any
+// earlier enrollment that was never confirmed.
+func (s *TwoFactorStore) EnrollTOTP(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// ConfirmTOTP enables two-factor authentication with the step of the first
+// valid code and stores the hashed recovery codes.
+func (s *TwoFactorStore) ConfirmTOTP(ctx context.Context, userID, step int64, recoveryCodes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE user_totp
+			SET confirmed_at = NOW(), last_used_step = $2
+			WHERE user_id = $1 AND confirmed_at IS NULL
+		`
+
+		res, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrTwoFactorEnabled
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+}
+
+// UseTOTPStep records a valid code, refusing steps that were already used.
+func (s *TwoFactorStore) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrCodeReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes one of the user's hashed recovery codes.
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		UPDATE user_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code = decode($2, 'hex') AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, code)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates every recovery code of the user in favor
+// of the new hashed codes.
+func (s *TwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryCodes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+}
+
+// Disable removes the user's secret and recovery codes.
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// CreateChallenge stores the hashed token that carries a half finished login
+// from the password step to the code step.
+func (s *TwoFactorStore) CreateChallenge(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO mfa_challenges (token, user_id, expiry)
+		VALUES (decode($1, 'hex'), $2, $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AttemptChallenge counts an attempt against an unexpired challenge and
+// returns the user it belongs to. Challenges with maxAttempts attempts are
+// treated as unknown.
+func (s *TwoFactorStore) AttemptChallenge(ctx context.Context, token string, maxAttempts int) (int64, error) {
+	query := `
+		UPDATE mfa_challenges
+		SET attempts = attempts + 1
+		WHERE token = decode($1, 'hex') AND expiry > NOW() AND attempts < $2
+		RETURNING user_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, token, maxAttempts).Scan(&userID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (s *TwoFactorStore) DeleteChallenge(ctx context.Context, token string) error {
+	query := `DELETE FROM mfa_challenges WHERE token = decode($1, 'hex')`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, token)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, recoveryCodes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_recovery_codes (user_id, code)
+		VALUES ($1, decode($2, 'hex'))
+	`
+
+	for _, code := range recoveryCodes {
+		if _, err := tx.ExecContext(ctx, query, userID, code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UserBlock keeps the blocked user from partnering with, and so pinging, the
+// blocker.
+type UserBlock struct {
+	BlockerID       int64     `json:"blocker_id"`
+	BlockedID       int64     `json:"blocked_id"`
+	BlockedUsername string    `json:"blocked_username"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type UserBlockStore struct {
+	db *sql.DB
+}
+
+// Block stores the block, or keeps the existing one, and ends any
+// partnership and pending partner request between the two users. It fails
+// with ErrNotFound when either user doesn't exist.
+func (s *UserBlockStore) Block(ctx context.Context, block *UserBlock) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// locked like linkPartners does, so the two users can't be partnered
+		// while the block goes in
+		if err := lockUserPair(ctx, tx, block.BlockerID, block.BlockedID); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO user_blocks (blocker_id, blocked_id)
+			VALUES ($1, $2)
+			ON CONFLICT (blocker_id, blocked_id) DO UPDATE
+			SET blocker_id = EXCLUDED.blocker_id
+			RETURNING created_at
+		`
+
+		err := tx.QueryRowContext(ctx, query, block.BlockerID, block.BlockedID).Scan(&block.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		err = endPartnership(ctx, tx, block.BlockerID, block.BlockedID, block.BlockerID, PartnershipBlocked)
+		if err != nil && err != ErrPartnerNotFound {
+			return err
+		}
+
+		query = `
+			UPDATE partner_requests
+			SET status = $1, responded_at = NOW()
+			WHERE status = $2 AND (
+				(sender_id = $3 AND recipient_id = $4) OR (sender_id = $4 AND recipient_id = $3)
+			)
+		`
+
+		_, err = tx.ExecContext(
+			ctx,
+			query,
+			PartnerRequestCancelled,
+			PartnerRequestPending,
+			block.BlockerID,
+			block.BlockedID,
+		)
+		return err
+	})
+}
+
+// GetByBlockerID returns the users the blocker blocked, newest first.
+func (s *UserBlockStore) GetByBlockerID(ctx context.Context, blockerID int64) ([]UserBlock, error) {
+	query := `
+		SELECT b.blocker_id, b.blocked_id, u.username, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []UserBlock{}
+	for rows.Next() {
+		var block UserBlock
+		err := rows.Scan(
+			&block.BlockerID,
+			&block.BlockedID,
+			&block.BlockedUsername,
+			&block.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, rows.Err()
+}
+
+// Unblock removes the block. The users aren't partnered again.
+func (s *UserBlockStore) Unblock(ctx context.Context, blockerID, blockedID int64) error {
+	query := `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// isBlocked reports whether either user blocked the other.
+func isBlocked(ctx context.Context, tx *sql.Tx, userID, otherID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`
+
+	var blocked bool
+	err := tx.QueryRowContext(ctx, query, userID, otherID).Scan(&blocked)
+
+	return blocked, err
+}
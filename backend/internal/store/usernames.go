@@ -0,0 +1,35 @@
+package store
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables folds characters that look alike onto one of them, so that
+// "аdm1n" with a Cyrillic "а" and "admin" share a skeleton. Every character
+// is folded on its own, so migrations can fold the same way with translate().
+var confusables = strings.NewReplacer(
+	// Cyrillic
+	"а", "a", "в", "b", "с", "c", "ԁ", "d", "е", "e", "һ", "h", "і", "l", "ј", "j",
+	"к", "k", "ӏ", "l", "м", "m", "н", "h", "о", "o", "р", "p", "ԛ", "q", "г", "r",
+	"ѕ", "s", "т", "t", "ѵ", "v", "ԝ", "w", "х", "x", "у", "y",
+	// Greek
+	"α", "a", "β", "b", "ε", "e", "η", "n", "ι", "l", "κ", "k", "ν", "v", "ο", "o",
+	"ρ", "p", "τ", "t", "υ", "u", "χ", "x", "γ", "y",
+	// Latin and digits
+	"i", "l", "0", "o", "1", "l", "3", "e", "5", "s",
+	// separators
+	".", "", "_", "", "-", "",
+)
+
+// confusablePairs are letter pairs that look like a single letter. They are
+// folded after confusables.
+var confusablePairs = strings.NewReplacer("rn", "m", "vv", "w")
+
+// UsernameSkeleton is the form two usernames share when they look alike.
+// Users are unique by skeleton as well as by username.
+func UsernameSkeleton(username string) string {
+	skeleton := strings.ToLower(norm.NFKC.String(strings.TrimSpace(username)))
+	return confusablePairs.Replace(confusables.Replace(skeleton))
+}
@@ -7,12 +7,12 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrDuplicateEmail    = errors.New("a user with that email already exists")
 	ErrDuplicateUsername = errors.New("a user with that username already exists")
+	ErrAlreadyPartnered  = errors.New("user already has a partner")
 )
 
 type User struct {
@@ -25,25 +25,11 @@ type User struct {
 	Verified           bool          `json:"verified"`             // email is verified
 	UpdatedAt          time.Time     `json:"updated_at"`           // last time user was updated
 	CreatedAt          time.Time     `json:"created_at"`           // user's account creation date
-	PingedPartnerCount int64         `json:"pinged_partner_count"` // number of times user has pinged partner without response
-	PartnerID          sql.NullInt64 `json:"partner_id"`           // user's partner's userID
-}
-
-type password struct {
-	text *string
-	hash []byte
-}
-
-func (p *password) Set(text string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
-
-	p.text = &text
-	p.hash = hash
-
-	return nil
+	PingedPartnerCount int64         `json:"pinged_partner_count"` // number of times user has pinged the current partner
+	PartnerID          sql.NullInt64 `json:"partner_id"`           // current partner's userID
+	RoleID             int64         `json:"role_id"`              // user's permission role
+	Role               Role          `json:"role"`
+	MagicLinkEnabled   bool          `json:"magic_link_enabled"` // user can sign in by email link
 }
 
 type UserStore struct {
@@ -52,30 +38,41 @@ type UserStore struct {
 
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
-		INSERT INTO users (username, password, email)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at
+		INSERT INTO users (username, username_skeleton, password, email, role_id)
+		VALUES ($1, $2, $3, $4, (SELECT id FROM roles WHERE name = $5))
+		RETURNING id, role_id, magic_link_enabled, created_at, updated_at
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
+	role := user.Role.Name
+	if role == "" {
+		role = "user"
+	}
+
 	err := tx.QueryRowContext(
 		ctx,
 		query,
 		user.Username,
+		UsernameSkeleton(user.Username),
 		user.Password.hash,
 		user.Email,
+		role,
 	).Scan(
 		&user.ID,
+		&user.RoleID,
+		&user.MagicLinkEnabled,
 		&user.CreatedAt,
+		&user.UpdatedAt,
 	)
 
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "users_username_skeleton_key"`:
 			return ErrDuplicateUsername
 		default:
 			return err
@@ -87,9 +84,18 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 
 func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 	query := `
-		SELECT id, username, email, pinged, last_pinged_at, verified, pinged_partner_count, partner_id, updated_at, created_at
-		FROM users
-		WHERE id = $1
+		SELECT u.id, u.username, u.email, u.pinged, u.last_pinged_at, u.verified, COALESCE(p.pings, 0), p.partner_id, u.magic_link_enabled, u.updated_at, u.created_at,
+			r.id, r.name, r.level, COALESCE(r.description, '')
+		FROM users u
+		JOIN roles r ON u.role_id = r.id
+		LEFT JOIN LATERAL (
+			SELECT
+				CASE WHEN user_a = u.id THEN user_b ELSE user_a END AS partner_id,
+				CASE WHEN user_a = u.id THEN user_a_pings ELSE user_b_pings END AS pings
+			FROM partnerships
+			WHERE ended_at IS NULL AND u.id IN (user_a, user_b)
+		) p ON TRUE
+		WHERE u.id = $1
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -105,8 +111,13 @@ func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 		&user.Verified,
 		&user.PingedPartnerCount,
 		&user.PartnerID,
+		&user.MagicLinkEnabled,
 		&user.UpdatedAt,
 		&user.CreatedAt,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
 	)
 
 	if err != nil {
@@ -118,6 +129,61 @@ func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 		}
 	}
 
+	user.RoleID = user.Role.ID
+
+	return &user, nil
+}
+
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT u.id, u.username, u.email, u.password, u.pinged, u.last_pinged_at, u.verified, COALESCE(p.pings, 0), p.partner_id, u.magic_link_enabled, u.updated_at, u.created_at,
+			r.id, r.name, r.level, COALESCE(r.description, '')
+		FROM users u
+		JOIN roles r ON u.role_id = r.id
+		LEFT JOIN LATERAL (
+			SELECT
+				CASE WHEN user_a = u.id THEN user_b ELSE user_a END AS partner_id,
+				CASE WHEN user_a = u.id THEN user_a_pings ELSE user_b_pings END AS pings
+			FROM partnerships
+			WHERE ended_at IS NULL AND u.id IN (user_a, user_b)
+		) p ON TRUE
+		WHERE u.email = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var user User
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Pinged,
+		&user.LastPingedAt,
+		&user.Verified,
+		&user.PingedPartnerCount,
+		&user.PartnerID,
+		&user.MagicLinkEnabled,
+		&user.UpdatedAt,
+		&user.CreatedAt,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
+	)
+
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	user.RoleID = user.Role.ID
+
 	return &user, nil
 }
 
@@ -138,18 +204,50 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 	})
 }
 
+// Reinvite replaces the invitations of the user with a new one.
+func (s *UserStore) Reinvite(ctx context.Context, userID int64, token string, invitationExp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		return s.createUserInvitation(ctx, tx, token, invitationExp, userID)
+	})
+}
+
 func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token string, exp time.Duration, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_invitations (token, user_id, expiry)
+		VALUES (decode($1, 'hex'), $2, $3)
+	`
+
+	_, err := tx.ExecContext(ctx, query, token, userID, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateWithIdentity registers an already verified user that signed in
+// through an external identity provider.
+func (s *UserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
-		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-		defer cancel()
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
 
-		query := `
-			INSERT INTO user_invitations (token, user_id, expires_at)
-			VALUES ($1, NOW() + $2, $3)
-		`
+		// the issuer already verified the email
+		user.Verified = true
+		if err := s.update(ctx, tx, user); err != nil {
+			return err
+		}
 
-		_, err := tx.ExecContext(ctx, query, token, userID, exp)
-		if err != nil {
+		identity.UserID = user.ID
+		if err := createIdentity(ctx, tx, identity); err != nil {
 			return err
 		}
 
@@ -157,50 +255,92 @@ func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token
 	})
 }
 
-func (s *UserStore) Delete(ctx context.Context, id int64) error {
+// Activate verifies the user owning the invitation token and consumes the invitation.
+func (s *UserStore) Activate(ctx context.Context, token string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// find the user the token belongs to
+		user, err := s.getUserFromInvitation(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		// verify the user
+		user.Verified = true
+		if err := s.update(ctx, tx, user); err != nil {
+			return err
+		}
+
+		// clean the invitations
+		if err := s.deleteUserInvitations(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		// the activation proves the email, so a partner who invited it can
+		// be paired now
+		return pairInvitedUser(ctx, tx, user)
+	})
+}
+
+func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
 	query := `
-		DELETE FROM users
-		WHERE id = $1
+		SELECT u.id, u.username, u.email, u.verified, u.updated_at, u.created_at, ui.expiry
+		FROM users u
+		JOIN user_invitations ui ON u.id = ui.user_id
+		WHERE ui.token = decode($1, 'hex')
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	var user User
+	var expiry time.Time
+	err := tx.QueryRowContext(ctx, query, token).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Verified,
+		&user.UpdatedAt,
+		&user.CreatedAt,
+		&expiry,
+	)
 
-	rows, err := res.RowsAffected()
 	if err != nil {
-		return err
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	if rows == 0 {
-		return ErrNotFound
+	if time.Now().After(expiry) {
+		return nil, ErrTokenExpired
 	}
 
-	return nil
+	return &user, nil
 }
 
-func (s *UserStore) Update(ctx context.Context, user *User) error {
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
+func (s *UserStore) update(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
 		UPDATE users
-		SET username = $1, email = $2, updated_at = NOW()
-		WHERE id = $3 AND updated_at = $4
-    RETURNING updated_at
+		SET username = $1, username_skeleton = CASE WHEN username = $1 THEN username_skeleton ELSE $6 END,
+			email = $2, verified = $3, updated_at = NOW()
+		WHERE id = $4 AND updated_at = $5
+		RETURNING updated_at
 	`
 
-	err := s.db.QueryRowContext(
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
 		ctx,
 		query,
 		user.Username,
 		user.Email,
+		user.Verified,
 		user.ID,
 		user.UpdatedAt,
+		UsernameSkeleton(user.Username),
 	).Scan(&user.UpdatedAt)
 
 	if err != nil {
@@ -215,147 +355,216 @@ func (s *UserStore) Update(ctx context.Context, user *User) error {
 	return nil
 }
 
-// Partner sets two users as partners.
-func (s *UserStore) Partner(ctx context.Context, user *User, partner *User) error {
+func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM user_invitations WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the user. Their current partnership is ended first, so it
+// stays in the partner's history.
+func (s *UserStore) Delete(ctx context.Context, id int64) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
 		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 		defer cancel()
 
 		query := `
-		UPDATE users
-		SET partner_id = $1, updated_at = NOW()
-		WHERE id = $2 AND updated_at = $3
-		RETURNING updated_at
-	`
+			SELECT CASE WHEN user_a = $1 THEN user_b ELSE user_a END
+			FROM partnerships
+			WHERE ended_at IS NULL AND $1 IN (user_a, user_b)
+		`
 
-		err := tx.QueryRowContext(
-			ctx,
-			query,
-			partner.ID,
-			user.ID,
-			user.UpdatedAt,
-		).Scan(&user.UpdatedAt)
-		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
+		var partnerID int64
+		err := tx.QueryRowContext(ctx, query, id).Scan(&partnerID)
+		switch err {
+		case nil:
+			if err := lockUserPair(ctx, tx, id, partnerID); err != nil {
+				return err
+			}
+
+			err = endPartnership(ctx, tx, id, partnerID, id, PartnershipDeleted)
+			if err != nil && err != ErrPartnerNotFound {
 				return err
 			}
+		case sql.ErrNoRows:
+		default:
+			return err
 		}
 
 		query = `
-		UPDATE users
-		SET partner_id = $1, updated_at = NOW()
-		WHERE id = $2 AND updated_at = $3
-		RETURNING updated_at
-	`
+			DELETE FROM users
+			WHERE id = $1
+		`
 
-		err = tx.QueryRowContext(
-			ctx,
-			query,
-			user.ID,
-			partner.ID,
-			partner.UpdatedAt,
-		).Scan(&partner.UpdatedAt)
+		res, err := tx.ExecContext(ctx, query, id)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
-				return err
-			}
+			return err
 		}
 
-		// Commit the transaction.
-		if err = tx.Commit(); err != nil {
+		rows, err := res.RowsAffected()
+		if err != nil {
 			return err
 		}
 
+		if rows == 0 {
+			return ErrNotFound
+		}
+
 		return nil
 	})
 }
 
-// Unpartner removes the partner relationship between two users.
-func (s *UserStore) Unpartner(ctx context.Context, user *User) error {
-	return withTx(s.db, ctx, func(tx *sql.Tx) error {
-		if !user.PartnerID.Valid {
-			return ErrPartnerNotFound
-		}
+// Update saves the user's profile. The email isn't part of it, it only
+// changes once the new address confirms an EmailChanges entry. The username
+// skeleton is only replaced on a rename, users who shared one before
+// skeletons were unique keep theirs unset until then.
+func (s *UserStore) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
 
-		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-		defer cancel()
+	query := `
+		UPDATE users
+		SET username = $1, username_skeleton = CASE WHEN username = $1 THEN username_skeleton ELSE $5 END,
+			magic_link_enabled = $2, updated_at = NOW()
+		WHERE id = $3 AND updated_at = $4
+		RETURNING updated_at
+	`
 
-		var partnerUpdatedAt time.Time
-		query := `
-				SELECT updated_at
-				FROM users
-				WHERE id = $1
-		`
-		err := tx.QueryRowContext(
-			ctx,
-			query,
-			user.PartnerID.Int64,
-		).Scan(&partnerUpdatedAt)
-		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
-				return err
-			}
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		user.Username,
+		user.MagicLinkEnabled,
+		user.ID,
+		user.UpdatedAt,
+		UsernameSkeleton(user.Username),
+	).Scan(&user.UpdatedAt)
+
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return ErrNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "users_username_skeleton_key"`:
+			return ErrDuplicateUsername
+		default:
+			return err
 		}
+	}
 
-		query = `
-			UPDATE users
-			SET pinged = false, pinged_partner_count = 0, partner_id = NULL, updated_at = NOW()
-			WHERE id = $1 AND updated_at = $2
-			RETURNING updated_at
-		`
+	return nil
+}
 
-		err = tx.QueryRowContext(
-			ctx,
-			query,
-			user.ID,
-			user.UpdatedAt,
-		).Scan(&user.UpdatedAt)
-		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
-				return err
-			}
-		}
+// DeleteExpiredInvitations removes up to limit invitations past their expiry
+// and returns how many it removed.
+func (s *UserStore) DeleteExpiredInvitations(ctx context.Context, limit int) (int64, error) {
+	query := `
+		DELETE FROM user_invitations
+		WHERE token IN (
+			SELECT token
+			FROM user_invitations
+			WHERE expiry < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+	`
 
-		query = `
-			UPDATE users
-			SET pinged = false, pinged_partner_count = 0, partner_id = NULL, updated_at = NOW()
-			WHERE id = $1 AND updated_at = $2
-			RETURNING updated_at
-		`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
 
-		var newPartnerUpdatedAt time.Time
-		err = tx.QueryRowContext(
-			ctx,
-			query,
-			user.PartnerID.Int64,
-			partnerUpdatedAt,
-		).Scan(&newPartnerUpdatedAt)
-		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
-				return err
-			}
+	res, err := s.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteUnverified removes up to limit users that never activated their
+// account and registered before createdBefore, and returns how many it
+// removed. Users whose invitation is still live are spared, a resent
+// invitation may outlive the grace period. Only the deleted rows are locked,
+// rows another transaction holds are skipped until the next batch.
+func (s *UserStore) DeleteUnverified(ctx context.Context, createdBefore time.Time, limit int) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE id IN (
+			SELECT id
+			FROM users
+			WHERE verified = FALSE AND created_at < $1 AND NOT EXISTS (
+				SELECT 1
+				FROM user_invitations i
+				WHERE i.user_id = users.id AND i.expiry > NOW()
+			)
+			ORDER BY created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, createdBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// UpdatePassword replaces the stored hash of the user's password.
+func (s *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET password = $1
+		WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, user.Password.hash, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Unpartner ends the current partnership of the user. endedBy is the user
+// who ended it, which is a moderator when reason is PartnershipModerated.
+func (s *UserStore) Unpartner(ctx context.Context, user *User, endedBy int64, reason string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if !user.PartnerID.Valid {
+			return ErrPartnerNotFound
 		}
 
-		return nil
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		return endPartnership(ctx, tx, user.ID, user.PartnerID.Int64, endedBy, reason)
 	})
 }
 
-// Pings a user's partner and updates the user's pinged_partner_count.
+// Pings a user's partner and counts the ping on their partnership.
 func (s *UserStore) Ping(ctx context.Context, user *User) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
 		if !user.PartnerID.Valid {
@@ -365,44 +574,20 @@ func (s *UserStore) Ping(ctx context.Context, user *User) error {
 		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 		defer cancel()
 
-		var partnerUpdatedAt time.Time
 		query := `
-				SELECT updated_at
-				FROM users
-				WHERE id = $1
-		`
-		err := tx.QueryRowContext(
-			ctx,
-			query,
-			user.PartnerID.Int64,
-		).Scan(&partnerUpdatedAt)
-		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
-				return err
-			}
-		}
-
-		query = `
-			UPDATE users
-			SET pinged_partner_count = pinged_partner_count + 1,
-					updated_at = NOW()
-			WHERE id = $1 AND updated_at = $2
-			RETURNING updated_at
+			UPDATE partnerships
+			SET user_a_pings = user_a_pings + CASE WHEN user_a = $1 THEN 1 ELSE 0 END,
+				user_b_pings = user_b_pings + CASE WHEN user_b = $1 THEN 1 ELSE 0 END
+			WHERE ended_at IS NULL AND $1 IN (user_a, user_b)
+			RETURNING CASE WHEN user_a = $1 THEN user_b ELSE user_a END
 		`
 
-		err = tx.QueryRowContext(
-			ctx,
-			query,
-			user.ID,
-			user.UpdatedAt,
-		).Scan(&user.UpdatedAt)
+		var partnerID int64
+		err := tx.QueryRowContext(ctx, query, user.ID).Scan(&partnerID)
 		if err != nil {
 			switch err {
 			case sql.ErrNoRows:
-				return ErrNotFound
+				return ErrPartnerNotFound
 			default:
 				return err
 			}
@@ -411,27 +596,11 @@ func (s *UserStore) Ping(ctx context.Context, user *User) error {
 		query = `
 			UPDATE users
 			SET pinged = true, last_pinged_at = NOW(), updated_at = NOW()
-			WHERE id = $1 AND updated_at = $2
-			RETURNING updated_at
+			WHERE id = $1
 		`
 
-		var newPartnerUpdatedAt time.Time
-		err = tx.QueryRowContext(
-			ctx,
-			query,
-			user.PartnerID.Int64,
-			partnerUpdatedAt,
-		).Scan(&newPartnerUpdatedAt)
-		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return ErrNotFound
-			default:
-				return err
-			}
-		}
-
-		return nil
+		_, err = tx.ExecContext(ctx, query, partnerID)
+		return err
 	})
 }
 
@@ -467,6 +636,25 @@ func (s *UserStore) Pong(ctx context.Context, user *User) error {
 			}
 		}
 
+		query = `
+			UPDATE partnerships
+			SET user_a_pongs = user_a_pongs + CASE WHEN user_a = $1 THEN 1 ELSE 0 END,
+				user_b_pongs = user_b_pongs + CASE WHEN user_b = $1 THEN 1 ELSE 0 END
+			WHERE ended_at IS NULL AND $1 IN (user_a, user_b)
+			RETURNING id
+		`
+
+		var partnershipID int64
+		err = tx.QueryRowContext(ctx, query, user.ID).Scan(&partnershipID)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrPartnerNotFound
+			default:
+				return err
+			}
+		}
+
 		return nil
 	})
 }
@@ -0,0 +1,271 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateCredential = errors.New("a passkey with that name or id already exists")
+
+// WebAuthnCredential is a passkey registered by a user.
+type WebAuthnCredential struct {
+	ID              int64        `json:"id"`
+	UserID          int64        `json:"user_id"`
+	Name            string       `json:"name"`
+	CredentialID    []byte       `json:"credential_id"`
+	PublicKey       []byte       `json:"-"`
+	AttestationType string       `json:"-"`
+	AAGUID          []byte       `json:"-"`
+	SignCount       uint32       `json:"sign_count"`
+	Transports      []string     `json:"transports"`
+	BackupEligible  bool         `json:"backup_eligible"`
+	BackupState     bool         `json:"backup_state"`
+	LastUsedAt      sql.NullTime `json:"last_used_at"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+type WebAuthnStore struct {
+	db *sql.DB
+}
+
+func (s *WebAuthnStore) Create(ctx context.Context, credential *WebAuthnCredential) error {
+	query := `
+		INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		credential.UserID,
+		credential.Name,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.AAGUID,
+		int64(credential.SignCount),
+		pq.Array(credential.Transports),
+		credential.BackupEligible,
+		credential.BackupState,
+	).Scan(
+		&credential.ID,
+		&credential.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "webauthn_credentials_user_id_name_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "webauthn_credentials_credential_id_key"`:
+			return ErrDuplicateCredential
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *WebAuthnStore) GetByUserID(ctx context.Context, userID int64) ([]WebAuthnCredential, error) {
+	query := `
+		SELECT id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, last_used_at, created_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []WebAuthnCredential{}
+	for rows.Next() {
+		var credential WebAuthnCredential
+		var signCount int64
+		err := rows.Scan(
+			&credential.ID,
+			&credential.UserID,
+			&credential.Name,
+			&credential.CredentialID,
+			&credential.PublicKey,
+			&credential.AttestationType,
+			&credential.AAGUID,
+			&signCount,
+			pq.Array(&credential.Transports),
+			&credential.BackupEligible,
+			&credential.BackupState,
+			&credential.LastUsedAt,
+			&credential.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credential.SignCount = uint32(signCount)
+
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// RecordLogin stores the new signature counter of a credential after a
+// successful assertion.
+func (s *WebAuthnStore) RecordLogin(ctx context.Context, credentialID []byte, signCount uint32, backupState bool) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, backup_state = $3, last_used_at = NOW()
+		WHERE credential_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, credentialID, int64(signCount), backupState)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *WebAuthnStore) Rename(ctx context.Context, userID, id int64, name string) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET name = $3
+		WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID, name)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "webauthn_credentials_user_id_name_key"`:
+			return ErrDuplicateCredential
+		default:
+			return err
+		}
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *WebAuthnStore) Delete(ctx context.Context, userID, id int64) error {
+	query := `
+		DELETE FROM webauthn_credentials
+		WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CreateCeremony stores the JSON encoded state of a registration or login
+// ceremony until the client finishes it. userID is zero for logins.
+func (s *WebAuthnStore) CreateCeremony(ctx context.Context, userID int64, session []byte, exp time.Duration) (string, error) {
+	query := `
+		INSERT INTO webauthn_ceremonies (user_id, session, expiry)
+		VALUES (NULLIF($1, 0), $2, $3)
+		RETURNING id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var id string
+	err := s.db.QueryRowContext(ctx, query, userID, session, time.Now().Add(exp)).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// DeleteExpiredCeremonies removes up to limit ceremonies that were never
+// finished and returns how many it removed.
+func (s *WebAuthnStore) DeleteExpiredCeremonies(ctx context.Context, limit int) (int64, error) {
+	query := `
+		DELETE FROM webauthn_ceremonies
+		WHERE id IN (
+			SELECT id
+			FROM webauthn_ceremonies
+			WHERE expiry < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ConsumeCeremony deletes an unexpired ceremony of the user and returns its
+// state, so every ceremony can only be finished once.
+func (s *WebAuthnStore) ConsumeCeremony(ctx context.Context, id string, userID int64) ([]byte, error) {
+	query := `
+		DELETE FROM webauthn_ceremonies
+		WHERE id = $1 AND COALESCE(user_id, 0) = $2 AND expiry > NOW()
+		RETURNING session
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var session []byte
+	err := s.db.QueryRowContext(ctx, query, id, userID).Scan(&session)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return session, nil
+}